@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxAdminBodySize limits the size of admin API request bodies
+const maxAdminBodySize = 1 << 20
+
+// AdminServer exposes an authenticated API to change backend endpoints at runtime
+type AdminServer struct {
+	config  *AdminConfig
+	backend *Backend
+	logger  *Logger
+	token   string
+}
+
+// NewAdminServer creates a new admin API server for backend
+func NewAdminServer(config *AdminConfig, backend *Backend, logger *Logger) *AdminServer {
+	return &AdminServer{
+		config:  config,
+		backend: backend,
+		logger:  logger,
+	}
+}
+
+// Run starts the admin API server
+func (a *AdminServer) Run(ctx context.Context) error {
+	a.token = a.config.Token
+	if a.config.TokenFile != "" {
+		data, err := os.ReadFile(a.config.TokenFile)
+		if err != nil {
+			return fmt.Errorf("admin: failed to read token file: %w", err)
+		}
+		a.token = strings.TrimSpace(string(data))
+	}
+	if a.token == "" {
+		return fmt.Errorf("admin: token is empty")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config", a.handleGetConfig)
+	mux.HandleFunc("GET /endpoints", a.handleListEndpoints)
+	mux.HandleFunc("POST /endpoints", a.handleAddEndpoint)
+	mux.HandleFunc("GET /endpoints/{path...}", a.handleGetEndpoint)
+	mux.HandleFunc("PUT /endpoints/{path...}", a.handleReplaceEndpoint)
+	mux.HandleFunc("PATCH /endpoints/{path...}", a.handlePatchEndpoint)
+	mux.HandleFunc("DELETE /endpoints/{path...}", a.handleDeleteEndpoint)
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", a.config.Port),
+		Handler: a.authMiddleware(mux),
+	}
+
+	a.logger.Info("Starting admin API on port %d...", a.config.Port)
+
+	errChan := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errChan <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	case err := <-errChan:
+		return fmt.Errorf("admin server error: %w", err)
+	}
+}
+
+// authMiddleware rejects requests without the configured bearer token
+func (a *AdminServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			a.logger.Warn("Admin: rejected unauthenticated %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleGetConfig returns the effective backend configuration
+func (a *AdminServer) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	config := a.backend.Config()
+	if config.Admin != nil {
+		admin := *config.Admin
+		admin.Token = ""
+		config.Admin = &admin
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleListEndpoints returns all backend endpoints
+func (a *AdminServer) handleListEndpoints(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.backend.Endpoints())
+}
+
+// handleGetEndpoint returns a single backend endpoint
+func (a *AdminServer) handleGetEndpoint(w http.ResponseWriter, r *http.Request) {
+	endpoints := a.backend.Endpoints()
+	i, status, err := findEndpoint(endpoints, endpointRef(r))
+	if err != nil {
+		writeJSONError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, endpoints[i])
+}
+
+// handleAddEndpoint adds a new backend endpoint
+func (a *AdminServer) handleAddEndpoint(w http.ResponseWriter, r *http.Request) {
+	var endpoint BackendEndpoint
+	if err := decodeEndpoint(r, &endpoint); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status := http.StatusCreated
+	err := a.backend.UpdateEndpoints(func(endpoints []BackendEndpoint) ([]BackendEndpoint, error) {
+		for _, existing := range endpoints {
+			if err := endpointsConflict(existing, endpoint); err != nil {
+				status = http.StatusConflict
+				return nil, err
+			}
+		}
+		return append(endpoints, endpoint), nil
+	})
+	a.respondToUpdate(w, r, status, err, endpoint.Path)
+}
+
+// handleReplaceEndpoint replaces an existing backend endpoint
+func (a *AdminServer) handleReplaceEndpoint(w http.ResponseWriter, r *http.Request) {
+	var endpoint BackendEndpoint
+	if err := decodeEndpoint(r, &endpoint); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.modifyEndpoint(w, r, endpointRef(r), func(existing BackendEndpoint) (BackendEndpoint, error) {
+		// The replacement keeps the path and name it was addressed by unless it sets its own
+		if endpoint.Path == "" {
+			endpoint.Path = existing.Path
+		}
+		if endpoint.Name == "" {
+			endpoint.Name = existing.Name
+		}
+		return endpoint, nil
+	})
+}
+
+// handlePatchEndpoint updates only the fields present in the request body
+func (a *AdminServer) handlePatchEndpoint(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBodySize))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.modifyEndpoint(w, r, endpointRef(r), func(existing BackendEndpoint) (BackendEndpoint, error) {
+		err := unmarshalStrict(data, &existing)
+		return existing, err
+	})
+}
+
+// modifyEndpoint applies change to the endpoint addressed by ref
+func (a *AdminServer) modifyEndpoint(w http.ResponseWriter, r *http.Request, ref string, change func(BackendEndpoint) (BackendEndpoint, error)) {
+	status := http.StatusOK
+	err := a.backend.UpdateEndpoints(func(endpoints []BackendEndpoint) ([]BackendEndpoint, error) {
+		i, notFound, err := findEndpoint(endpoints, ref)
+		if err != nil {
+			status = notFound
+			return nil, err
+		}
+		changed, err := change(endpoints[i])
+		if err != nil {
+			status = http.StatusBadRequest
+			return nil, err
+		}
+		endpoints[i] = changed
+		return endpoints, nil
+	})
+	a.respondToUpdate(w, r, status, err, ref)
+}
+
+// handleDeleteEndpoint removes a backend endpoint
+func (a *AdminServer) handleDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	ref := endpointRef(r)
+	status := http.StatusOK
+	err := a.backend.UpdateEndpoints(func(endpoints []BackendEndpoint) ([]BackendEndpoint, error) {
+		i, notFound, err := findEndpoint(endpoints, ref)
+		if err != nil {
+			status = notFound
+			return nil, err
+		}
+		return append(endpoints[:i], endpoints[i+1:]...), nil
+	})
+	a.respondToUpdate(w, r, status, err, ref)
+}
+
+// respondToUpdate logs the outcome of an endpoint change and writes the endpoint list
+func (a *AdminServer) respondToUpdate(w http.ResponseWriter, r *http.Request, status int, err error, path string) {
+	if err != nil {
+		if status < 400 {
+			status = http.StatusUnprocessableEntity
+		}
+		a.logger.Warn("Admin: %s %s rejected: %v", r.Method, path, err)
+		writeJSONError(w, status, err)
+		return
+	}
+	a.logger.Info("Admin: %s %s applied by %s", r.Method, path, r.RemoteAddr)
+	writeJSON(w, status, a.backend.Endpoints())
+}
+
+// endpointRef returns the backend path or endpoint name addressed by an admin URL
+func endpointRef(r *http.Request) string {
+	return "/" + r.PathValue("path")
+}
+
+// findEndpoint returns the index of the endpoint named or serving ref. When
+// it fails, it also returns the status to answer with.
+func findEndpoint(endpoints []BackendEndpoint, ref string) (int, int, error) {
+	name := strings.TrimPrefix(ref, "/")
+	for i, endpoint := range endpoints {
+		if endpoint.Name != "" && endpoint.Name == name {
+			return i, 0, nil
+		}
+	}
+
+	found := -1
+	for i, endpoint := range endpoints {
+		if endpoint.Path != ref {
+			continue
+		}
+		if found >= 0 {
+			return -1, http.StatusConflict, fmt.Errorf("several endpoints serve %s, address one by name", ref)
+		}
+		found = i
+	}
+	if found < 0 {
+		return -1, http.StatusNotFound, fmt.Errorf("endpoint %s not found", ref)
+	}
+	return found, 0, nil
+}
+
+// decodeEndpoint reads an endpoint definition from a JSON (or YAML) request body
+func decodeEndpoint(r *http.Request, endpoint *BackendEndpoint) error {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBodySize))
+	if err != nil {
+		return err
+	}
+	return unmarshalStrict(data, endpoint)
+}
+
+// unmarshalStrict decodes JSON or YAML into v, rejecting unknown fields.
+// Going through YAML keeps field names and duration strings ("2s") identical
+// to the configuration file.
+func unmarshalStrict(data []byte, v interface{}) error {
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// writeJSON writes v as JSON using the configuration file field names
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var generic interface{}
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(generic)
+}
+
+// writeJSONError writes an error as a JSON object
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
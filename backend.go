@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // Backend represents the HTTP server component
@@ -14,6 +26,34 @@ type Backend struct {
 	logger         *Logger
 	metrics        *Metrics
 	metricsHandler http.Handler
+
+	mu     sync.Mutex             // Serializes endpoint changes
+	routes atomic.Pointer[router] // Routes for the current endpoints, swapped as a whole
+
+	stateMu sync.Mutex
+	states  map[string]*endpointState // By endpoint stream name, kept across route rebuilds
+
+	http2Faults bool // Whether the server was started with the HTTP/2 fault layer
+}
+
+// connInfoKey is the context key of the connInfo of a backend connection
+type connInfoKey struct{}
+
+// connInfo describes the connection a request arrived on
+type connInfo struct {
+	conn     net.Conn // As accepted by the server, after TLS
+	accepted time.Time
+}
+
+// withConnInfo records the connection in the context of its requests
+func withConnInfo(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, &connInfo{conn: conn, accepted: time.Now()})
+}
+
+// connInfoFrom returns the connection information in ctx, or nil
+func connInfoFrom(ctx context.Context) *connInfo {
+	info, _ := ctx.Value(connInfoKey{}).(*connInfo)
+	return info
 }
 
 // NewBackend creates a new HTTP backend server
@@ -27,34 +67,117 @@ func NewBackend(config *BackendConfig, logger *Logger, metrics *Metrics) *Backen
 
 // Run starts the HTTP server
 func (b *Backend) Run(ctx context.Context) error {
-	mux := http.NewServeMux()
-
-	// Register metrics endpoint
 	if b.metricsHandler != nil {
-		mux.Handle("/metrics", b.metricsHandler)
 		b.logger.Info("Registering Prometheus metrics endpoint: /metrics")
 	}
+	b.routes.Store(b.buildRouter(b.config.Endpoints))
 
-	// Register all configured endpoints
-	for _, endpoint := range b.config.Endpoints {
-		b.registerEndpoint(mux, endpoint)
+	// Build the DNS zones before opening any port, so a bad zone leaves nothing running
+	dnsListeners := make([]*DNSListener, 0, len(b.config.DNSListeners))
+	for _, dnsConfig := range b.config.DNSListeners {
+		dnsListener, err := NewDNSListener(dnsConfig, b.logger, b.metrics)
+		if err != nil {
+			return err
+		}
+		dnsListeners = append(dnsListeners, dnsListener)
 	}
 
+	// Requests are routed through whichever router is current, so endpoint
+	// changes apply to new requests without touching open connections
+	var maxConnectionAge time.Duration
+	if b.config.HTTP2 != nil {
+		maxConnectionAge = b.config.HTTP2.MaxConnectionAge
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitConnectionAge(w, r, maxConnectionAge)
+		b.routes.Load().ServeHTTP(w, r)
+	})
+
 	b.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", b.config.Port),
-		Handler: b.loggingMiddleware(mux),
+		Addr:        fmt.Sprintf(":%d", b.config.Port),
+		Handler:     b.loggingMiddleware(handler),
+		ConnContext: withConnInfo,
+	}
+
+	listener, err := net.Listen("tcp", b.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", b.config.Port, err)
+	}
+	listener = shapeListener(listener, b.config.Shaping, "http", b.metrics)
+	if b.config.ProxyProtocol {
+		listener = &proxyListener{Listener: listener, logger: b.logger}
 	}
+	if b.config.TLS == nil {
+		// Echo endpoints, including those added later, report plain HTTP/1.x headers in the order received
+		listener = &headerRecordingListener{Listener: listener}
+	}
+
+	// HTTP/2 connections only go through the fault layer when an endpoint needs it
+	b.mu.Lock()
+	b.http2Faults = hasHTTP2Faults(b.config.Endpoints...) || (b.config.Default != nil && hasHTTP2Faults(*b.config.Default))
+	b.mu.Unlock()
 
-	b.logger.Info("Starting HTTP backend server on port %d...", b.config.Port)
+	if b.config.TLS != nil {
+		tlsConfig, err := buildServerTLSConfig(b.config.TLS, b.logger)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to configure TLS: %w", err)
+		}
+		if err := configureServerProtocols(b.server, tlsConfig, b.config.HTTP2, b.http2Faults); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
+		listener = newTLSListener(listener, "http", tlsConfig, b.logger, b.metrics)
+		b.logger.Info("Starting HTTPS backend server on port %d (client auth: %s)...", b.config.Port, b.config.TLS.ClientAuth)
+	} else {
+		if err := configureServerProtocols(b.server, nil, b.config.HTTP2, b.http2Faults); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
+		if b.config.HTTP2 != nil && b.config.HTTP2.H2C {
+			b.logger.Info("Starting HTTP backend server on port %d (h2c enabled)...", b.config.Port)
+		} else {
+			b.logger.Info("Starting HTTP backend server on port %d...", b.config.Port)
+		}
+	}
 
 	// Start server in a goroutine
-	errChan := make(chan error, 1)
+	errChan := make(chan error, 2+len(b.config.TCPListeners)+len(b.config.DNSListeners))
 	go func() {
-		if err := b.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := b.server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			errChan <- err
 		}
 	}()
 
+	// Start raw TCP listeners
+	for _, tcpConfig := range b.config.TCPListeners {
+		tcpListener := NewTCPListener(tcpConfig, b.logger, b.metrics)
+		go func() {
+			if err := tcpListener.Run(ctx); err != nil && err != context.Canceled {
+				errChan <- err
+			}
+		}()
+	}
+
+	// Start DNS listeners
+	for _, dnsListener := range dnsListeners {
+		go func() {
+			if err := dnsListener.Run(ctx); err != nil && err != context.Canceled {
+				errChan <- err
+			}
+		}()
+	}
+
+	// Start admin API
+	if b.config.Admin != nil {
+		admin := NewAdminServer(b.config.Admin, b, b.logger)
+		go func() {
+			if err := admin.Run(ctx); err != nil && err != context.Canceled {
+				errChan <- err
+			}
+		}()
+	}
+
 	// Wait for context cancellation or server error
 	select {
 	case <-ctx.Done():
@@ -67,99 +190,273 @@ func (b *Backend) Run(ctx context.Context) error {
 	}
 }
 
-// registerEndpoint registers a single endpoint handler
-func (b *Backend) registerEndpoint(mux *http.ServeMux, endpoint BackendEndpoint) {
-	handler := b.createHandler(endpoint)
+// Endpoints returns a copy of the endpoints currently served
+func (b *Backend) Endpoints() []BackendEndpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.config.Endpoints)
+}
 
-	pattern := endpoint.Path
-	b.logger.Info("Registering endpoint: %s %s -> Status %d",
-		endpoint.Method, endpoint.Path, endpoint.StatusCode)
+// Config returns a copy of the backend configuration with the endpoints
+// currently served
+func (b *Backend) Config() BackendConfig {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	config := *b.config
+	config.Endpoints = slices.Clone(b.config.Endpoints)
+	return config
+}
 
-	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		// Check if method matches
-		if r.Method != endpoint.Method {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+// UpdateEndpoints validates a change to the endpoint list and swaps in the
+// resulting routes atomically. In-flight requests finish with the old settings.
+// update gets a deep copy, so neither it nor a failed validation can touch the
+// maps and structs of the endpoints being served.
+func (b *Backend) UpdateEndpoints(update func([]BackendEndpoint) ([]BackendEndpoint, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, err := cloneEndpoints(b.config.Endpoints)
+	if err != nil {
+		return err
+	}
+	endpoints, err := update(current)
+	if err != nil {
+		return err
+	}
+
+	for i := range endpoints {
+		if err := validateBackendEndpoint(&endpoints[i]); err != nil {
+			return fmt.Errorf("endpoint %s: %w", endpoints[i].Path, err)
 		}
-		handler(w, r)
-	})
+		for j := range i {
+			if err := endpointsConflict(endpoints[j], endpoints[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !b.http2Faults && hasHTTP2Faults(endpoints...) {
+		b.logger.Warn("Endpoint http2 faults need a restart: no endpoint had them when the backend started")
+	}
+	b.routes.Store(b.buildRouter(endpoints))
+	b.config.Endpoints = endpoints
+	return nil
+}
+
+// cloneEndpoints deep copies endpoints through their YAML form. Compiled
+// fields are left out; validation builds them again.
+func cloneEndpoints(endpoints []BackendEndpoint) ([]BackendEndpoint, error) {
+	data, err := yaml.Marshal(endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("copying endpoints: %w", err)
+	}
+	var clone []BackendEndpoint
+	if err := yaml.Unmarshal(data, &clone); err != nil {
+		return nil, fmt.Errorf("copying endpoints: %w", err)
+	}
+	return clone, nil
+}
+
+// endpointState is the state of an endpoint that outlives route rebuilds, so
+// changing one endpoint does not restart the fault schedules, random streams,
+// scenarios and template request counts of the others
+type endpointState struct {
+	faults    *faultDecider
+	scenario  *scenario // nil without a scenario
+	endpoint  BackendEndpoint
+	templates *responseTemplate // nil unless templated; replaced when the endpoint changes
+}
+
+// keepState returns the state of an endpoint, keeping each part of the state
+// it had before the routes were rebuilt unless the settings it follows changed
+func (b *Backend) keepState(endpoint BackendEndpoint) *endpointState {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	name := endpoint.streamName()
+	state, ok := b.states[name]
+	if !ok {
+		state = &endpointState{}
+		if b.states == nil {
+			b.states = make(map[string]*endpointState)
+		}
+		b.states[name] = state
+	}
+
+	if !ok || !reflect.DeepEqual(state.endpoint, endpoint) {
+		state.endpoint, state.templates = endpoint, nil
+		if endpoint.Template {
+			// Templates are checked when the endpoint is validated
+			state.templates, _ = newResponseTemplate(endpoint)
+		}
+	}
+	if state.faults == nil || state.faults.settings != newFaultSettings(endpoint) {
+		state.faults = newFaultDecider(endpoint)
+	}
+	switch {
+	case endpoint.Scenario == nil:
+		state.scenario = nil
+	case state.scenario == nil || !reflect.DeepEqual(state.scenario.config, *endpoint.Scenario):
+		state.scenario = newScenario(*endpoint.Scenario)
+	}
+	return state
+}
+
+// pruneState forgets the state of endpoints that no longer exist
+func (b *Backend) pruneState(endpoints []BackendEndpoint) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	names := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		names[endpoint.streamName()] = true
+	}
+	if b.config.Default != nil {
+		names[b.config.Default.streamName()] = true
+	}
+	for name := range b.states {
+		if !names[name] {
+			delete(b.states, name)
+		}
+	}
 }
 
 // createHandler creates a handler function for an endpoint
 func (b *Backend) createHandler(endpoint BackendEndpoint) http.HandlerFunc {
+	state := b.keepState(endpoint)
+	faults, scenario := state.faults, state.scenario
+	var delays *delaySampler
+	if endpoint.DelayDistribution != nil {
+		delays = newDelaySampler(endpoint.DelayDistribution, "delay "+endpoint.streamName())
+	}
+	var generated *bodyGenerator
+	if endpoint.Generate != nil {
+		generated = newBodyGenerator(endpoint.Generate, "body "+endpoint.streamName())
+	}
+	templates := state.templates
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Simulate connection drop or idle based on percentages
-		if endpoint.DropPercent > 0 || endpoint.IdlePercent > 0 {
-			// Generate random number 0-100
-			random := float64(time.Now().UnixNano()%10000) / 100.0
-
-			if random < endpoint.DropPercent {
-				// Drop connection: close without response
-				b.logger.Warn("Dropping connection for %s %s (%.1f%% drop rate)", r.Method, r.URL.Path, endpoint.DropPercent)
-				// Track drop metrics
-				b.metrics.BackendDroppedTotal.WithLabelValues(r.URL.Path, r.Method).Inc()
-				// Get underlying connection and close it
-				if hj, ok := w.(http.Hijacker); ok {
-					conn, _, err := hj.Hijack()
-					if err == nil {
-						conn.Close()
-						return
-					}
-				}
-				// Fallback: just return without writing anything
-				return
-			} else if random < (endpoint.DropPercent + endpoint.IdlePercent) {
-				// Idle connection: keep open but don't respond
-				idleDuration := endpoint.IdleDuration
-				if idleDuration == 0 {
-					idleDuration = 30 * time.Second
+		b.applyHTTP2Faults(w, r, endpoint)
+
+		// Requests the scenario of the endpoint lets through get the per-request faults
+		var step scenarioStep
+		var reason string
+		if scenario != nil {
+			step, reason = scenario.next(r)
+		}
+		outcome := step.fault
+		if step.ok() {
+			outcome, reason = faults.next(r.ProtoMajor == 2)
+		} else {
+			b.metrics.BackendScenarioFailures.WithLabelValues(r.URL.Path, r.Method, endpoint.Scenario.Type, step.String()).Inc()
+			reason = endpoint.Scenario.Type + ": " + reason
+		}
+		if step.status != 0 {
+			b.failScenario(w, r, endpoint, step.status, reason)
+			return
+		}
+
+		// Simulate connection drop, idle or stream reset
+		switch outcome {
+		case outcomeDrop:
+			// Drop connection: close without response
+			b.logger.Warn("Dropping connection for %s %s (%s)", r.Method, r.URL.Path, reason)
+			// Track drop metrics
+			b.metrics.BackendDroppedTotal.WithLabelValues(r.URL.Path, r.Method).Inc()
+			// Get underlying connection and close it
+			if hj, ok := w.(http.Hijacker); ok {
+				conn, _, err := hj.Hijack()
+				if err == nil {
+					conn.Close()
+					return
 				}
-				b.logger.Warn("Idling connection for %s %s for %v (%.1f%% idle rate)", 
-					r.Method, r.URL.Path, idleDuration, endpoint.IdlePercent)
-				// Track idle metrics
-				b.metrics.BackendIdledTotal.WithLabelValues(r.URL.Path, r.Method).Inc()
-				b.metrics.BackendIdleDuration.WithLabelValues(r.URL.Path, r.Method).Observe(idleDuration.Seconds())
-				time.Sleep(idleDuration)
-				// After idle, close without response
-				if hj, ok := w.(http.Hijacker); ok {
-					conn, _, err := hj.Hijack()
-					if err == nil {
-						conn.Close()
-						return
-					}
+			}
+			// HTTP/2 connections carry other streams and cannot be hijacked,
+			// so reset just this one
+			b.resetStream(r, endpoint)
+		case outcomeIdle:
+			// Idle connection: keep open but don't respond
+			idleDuration := endpoint.IdleDuration
+			if idleDuration == 0 {
+				idleDuration = 30 * time.Second
+			}
+			b.logger.Warn("Idling connection for %s %s for %v (%s)",
+				r.Method, r.URL.Path, idleDuration, reason)
+			// Track idle metrics
+			b.metrics.BackendIdledTotal.WithLabelValues(r.URL.Path, r.Method).Inc()
+			b.metrics.BackendIdleDuration.WithLabelValues(r.URL.Path, r.Method).Observe(idleDuration.Seconds())
+			time.Sleep(idleDuration)
+			// After idle, close without response
+			if hj, ok := w.(http.Hijacker); ok {
+				conn, _, err := hj.Hijack()
+				if err == nil {
+					conn.Close()
+					return
 				}
-				return
 			}
+			b.resetStream(r, endpoint)
+		case outcomeReset:
+			b.logger.Warn("Resetting stream for %s %s (%s)", r.Method, r.URL.Path, reason)
+			b.resetStream(r, endpoint)
 		}
 
 		// Normal response flow
 		// Apply artificial delay if configured
-		if endpoint.Delay > 0 {
+		if delays != nil {
+			delay := delays.sample()
+			b.metrics.BackendSampledDelay.WithLabelValues(r.URL.Path, r.Method).Observe(delay.Seconds())
+			time.Sleep(delay)
+		} else if endpoint.Delay > 0 {
 			time.Sleep(endpoint.Delay)
 		}
 
+		headers, body := endpoint.Headers, []byte(endpoint.Body)
+		if endpoint.Echo != nil {
+			w.Header().Set("Content-Type", "application/json")
+			body = echoRequest(r, endpoint.Echo, start)
+		} else if templates != nil {
+			var err error
+			if headers, body, err = templates.render(r, start); err != nil {
+				b.logger.Error("Rendering template for %s %s: %v", r.Method, r.URL.Path, err)
+				http.Error(w, "Template error", http.StatusInternalServerError)
+				return
+			}
+		}
+
 		// Set response headers
-		for key, value := range endpoint.Headers {
+		for key, value := range headers {
 			w.Header().Set(key, value)
 		}
+		var content io.Reader = bytes.NewReader(body)
+		size := int64(len(body))
+		if generated != nil {
+			content, size = generated.next()
+			if endpoint.Stream == nil {
+				w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			}
+		}
+		if endpoint.Stream != nil {
+			b.setStreamHeaders(w, r, endpoint.Stream, size)
+		}
 
 		// Set status code
 		w.WriteHeader(endpoint.StatusCode)
 
 		// Write response body
-		if endpoint.Body != "" {
-			w.Write([]byte(endpoint.Body))
+		bodySize := size
+		if endpoint.Stream != nil {
+			bodySize = b.streamBody(w, r, endpoint, content, size)
+		} else if size > 0 {
+			bodySize, _ = io.Copy(w, content)
 		}
 
 		duration := time.Since(start)
 		
 		// Track metrics
-		b.metrics.BackendRequestsTotal.WithLabelValues(r.URL.Path, r.Method, fmt.Sprintf("%d", endpoint.StatusCode)).Inc()
+		b.metrics.BackendRequestsTotal.WithLabelValues(r.URL.Path, r.Method, fmt.Sprintf("%d", endpoint.StatusCode), r.Proto).Inc()
 		b.metrics.BackendRequestDuration.WithLabelValues(r.URL.Path, r.Method).Observe(duration.Seconds())
-		if endpoint.Body != "" {
-			b.metrics.BackendResponseSize.WithLabelValues(r.URL.Path, r.Method).Observe(float64(len(endpoint.Body)))
+		if bodySize > 0 {
+			b.metrics.BackendResponseSize.WithLabelValues(r.URL.Path, r.Method).Observe(float64(bodySize))
 		}
 
 		b.logger.Debug("Handled %s %s -> %d (took %v)",
@@ -167,16 +464,32 @@ func (b *Backend) createHandler(endpoint BackendEndpoint) http.HandlerFunc {
 	}
 }
 
+// failScenario answers a request failed by the scenario of endpoint with status
+func (b *Backend) failScenario(w http.ResponseWriter, r *http.Request, endpoint BackendEndpoint, status int, reason string) {
+	b.logger.Warn("Failing %s %s with %d (%s)", r.Method, r.URL.Path, status, reason)
+	if retryAfter := endpoint.Scenario.RetryAfter; retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second).Seconds())))
+	}
+	http.Error(w, http.StatusText(status), status)
+	b.metrics.BackendRequestsTotal.WithLabelValues(r.URL.Path, r.Method, strconv.Itoa(status), r.Proto).Inc()
+}
+
 // loggingMiddleware logs all incoming requests
 func (b *Backend) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		// Log request
-		b.logger.Info("← %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		b.logger.Info("← %s %s %s from %s", r.Method, r.URL.Path, r.Proto, r.RemoteAddr)
 
 		// Log request headers if verbose
 		if b.logger.verbose {
+			if r.TLS != nil {
+				b.logger.Debug("  TLS: %s, %s, SNI %q", tls.VersionName(r.TLS.Version), tls.CipherSuiteName(r.TLS.CipherSuite), r.TLS.ServerName)
+				for _, cert := range r.TLS.PeerCertificates {
+					b.logger.Debug("  Client Certificate: %s (issuer: %s)", cert.Subject, cert.Issuer)
+				}
+			}
 			b.logger.Debug("  Request Headers:")
 			for key, values := range r.Header {
 				for _, value := range values {
@@ -207,3 +520,16 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack lets handlers drop HTTP/1.x connections through the wrapper
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
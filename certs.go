@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// generatedCerts holds the certificates created by generateCertificates
+type generatedCerts struct {
+	caPool *x509.CertPool
+	server *tls.Certificate
+	sni    map[int]*tls.Certificate // Keyed by index into TLSServerConfig.SNI
+}
+
+// certAuthority signs leaf certificates
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// generateCertificates creates a self-signed CA, a server certificate, a client
+// certificate and any auto-generated SNI certificates, and writes them to cfg.Dir
+func generateCertificates(cfg *AutoCertConfig, sni []SNICertificate) (*generatedCerts, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+
+	ca, err := newCertAuthority(cfg.ValidFor)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePEM(filepath.Join(cfg.Dir, "ca.crt"), "CERTIFICATE", ca.cert.Raw, 0o644); err != nil {
+		return nil, err
+	}
+	if err := writeKey(filepath.Join(cfg.Dir, "ca.key"), ca.key); err != nil {
+		return nil, err
+	}
+
+	hosts := cfg.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+	}
+
+	server, err := ca.issue(hosts[0], hosts, cfg.ValidFor, cfg.Expired, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPair(cfg.Dir, "tls", server); err != nil {
+		return nil, err
+	}
+
+	client, err := ca.issue("test-backend-client", nil, cfg.ValidFor, false, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPair(cfg.Dir, "client", client); err != nil {
+		return nil, err
+	}
+
+	generated := &generatedCerts{
+		caPool: x509.NewCertPool(),
+		server: server,
+		sni:    make(map[int]*tls.Certificate),
+	}
+	generated.caPool.AddCert(ca.cert)
+
+	for i, entry := range sni {
+		if entry.CertFile != "" {
+			continue
+		}
+		sniHosts := entry.Hosts
+		if len(sniHosts) == 0 {
+			sniHosts = entry.ServerNames
+		}
+		cert, err := ca.issue(sniHosts[0], sniHosts, cfg.ValidFor, entry.Expired, x509.ExtKeyUsageServerAuth)
+		if err != nil {
+			return nil, err
+		}
+		name := "sni-" + strings.NewReplacer("*", "wildcard", "/", "_").Replace(entry.ServerNames[0])
+		if err := writeKeyPair(cfg.Dir, name, cert); err != nil {
+			return nil, err
+		}
+		generated.sni[i] = cert
+	}
+
+	return generated, nil
+}
+
+// newCertAuthority creates a self-signed CA certificate
+func newCertAuthority(validFor time.Duration) (*certAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: "test-backend CA", Organization: []string{"test-backend"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validFor),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &certAuthority{cert: cert, key: key}, nil
+}
+
+// issue creates a leaf certificate signed by the CA
+func (ca *certAuthority) issue(commonName string, hosts []string, validFor time.Duration, expired bool, usage x509.ExtKeyUsage) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(validFor)
+	if expired {
+		notBefore = time.Now().Add(-validFor - 24*time.Hour)
+		notAfter = time.Now().Add(-24 * time.Hour)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"test-backend"}},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate for %s: %w", commonName, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// newSerialNumber returns a random certificate serial number
+func newSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
+
+// writeKeyPair writes <name>.crt (leaf followed by CA) and <name>.key into dir
+func writeKeyPair(dir, name string, cert *tls.Certificate) error {
+	var chain []byte
+	for _, der := range cert.Certificate {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), chain, 0o644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return writeKey(filepath.Join(dir, name+".key"), cert.PrivateKey.(*ecdsa.PrivateKey))
+}
+
+// writeKey writes an EC private key in PEM format
+func writeKey(filename string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %w", err)
+	}
+	return writePEM(filename, "EC PRIVATE KEY", der, 0o600)
+}
+
+// writePEM writes a single PEM block to filename
+func writePEM(filename, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filename, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
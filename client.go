@@ -1,50 +1,210 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptrace"
+	"regexp"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Client represents the HTTP client component
 type Client struct {
 	config  *ClientConfig
-	client  *http.Client
 	logger  *Logger
 	metrics *Metrics
+
+	mu      sync.Mutex
+	runCtx  context.Context   // Request generation context, set by Run
+	runners []*endpointRunner // Endpoints currently generating requests
+
+	stats      *runStats // Results for the end-of-run report
+	started    time.Time
+	reportOnce sync.Once
+	report     *RunReport
+
+	results *resultWriter // Per-request result stream, set by Run when enabled
+}
+
+// runCompleteError is returned by Run when a run with thresholds ends, carrying
+// the exit code of the process
+type runCompleteError struct {
+	exitCode int
+}
+
+func (e *runCompleteError) Error() string {
+	if e.exitCode == 0 {
+		return "run complete, all thresholds passed"
+	}
+	return fmt.Sprintf("run complete, thresholds violated (exit code %d)", e.exitCode)
+}
+
+// endpointRunner holds the per-endpoint state used to issue requests
+type endpointRunner struct {
+	config      EndpointConfig
+	client      *http.Client    // RequestTimeout is applied per request; the global timeout is handled by context in Run()
+	conns       *connTracker    // Connection tracking for the transport of client
+	dialer      *endpointDialer // Dialer with the DNS settings of tcp endpoints
+	expectRegex *regexp.Regexp  // Compiled tcp.expect_regex for tcp endpoints
+	expect      *expectations   // Compiled response assertions for http endpoints
+	requests    *requestBuilder // Headers and bodies of http requests
+	stats       *endpointStats  // Results for the end-of-run report
+	stop        chan struct{}   // Closed to stop generating requests; in-flight requests are not cancelled
 }
 
 // NewClient creates a new HTTP client
 func NewClient(config *ClientConfig, logger *Logger, metrics *Metrics) *Client {
 	return &Client{
 		config:  config,
-		client: &http.Client{
-			Timeout: config.RequestTimeout,
-			// RequestTimeout is applied per request, not globally to the client
-			// Global client timeout is handled by context in Run()
-		},
 		logger:  logger,
 		metrics: metrics,
+		stats:   newRunStats(),
+	}
+}
+
+// newEndpointRunner prepares the transport and state for an endpoint
+func (c *Client) newEndpointRunner(config *ClientConfig, endpoint EndpointConfig) (*endpointRunner, error) {
+	targetRPS := endpoint.RequestsPerSecond
+	if targetRPS == 0 && config.Interval > 0 {
+		targetRPS = float64(time.Second) / float64(config.Interval)
 	}
+
+	runner := &endpointRunner{
+		config: endpoint,
+		stats:  c.stats.endpoint(endpoint, targetRPS),
+		stop:   make(chan struct{}),
+	}
+
+	if endpoint.Type == "tcp" {
+		if endpoint.TCP.ExpectRegex != "" {
+			runner.expectRegex = regexp.MustCompile(endpoint.TCP.ExpectRegex)
+		}
+		dialer, err := newEndpointDialer(endpoint.DNS, config.RequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint [%s]: dns: %w", endpoint.Name, err)
+		}
+		dialer.shaper = newShaper(endpoint.Shaping, "client", endpoint.Name, c.metrics)
+		runner.dialer = dialer
+		return runner, nil
+	}
+
+	if endpoint.Expect != nil {
+		expect, err := compileExpectations(endpoint.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint [%s]: %w", endpoint.Name, err)
+		}
+		runner.expect = expect
+	}
+	requests, err := newRequestBuilder(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint [%s]: %w", endpoint.Name, err)
+	}
+	runner.requests = requests
+
+	runner.conns = newConnTracker(endpoint.Name, c.metrics)
+	httpClient, err := newHTTPClient(config, endpoint, runner.conns)
+	if err != nil {
+		return nil, err
+	}
+	runner.client = httpClient
+	return runner, nil
+}
+
+// newEndpointRunners prepares runners for every endpoint in config. Transports of
+// previous runners are reused when their settings did not change, so reloads
+// keep pooled connections.
+func (c *Client) newEndpointRunners(config *ClientConfig, previous []*endpointRunner) ([]*endpointRunner, error) {
+	runners := make([]*endpointRunner, 0, len(config.Endpoints))
+	for _, endpoint := range config.Endpoints {
+		runner, err := c.newEndpointRunner(config, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		for _, old := range previous {
+			if old.client != nil && runner.client != nil && old.config.Name == endpoint.Name &&
+				old.client.Timeout == runner.client.Timeout && sameTransport(old.config, endpoint) {
+				runner.client = old.client
+				runner.conns = old.conns
+			}
+		}
+		runners = append(runners, runner)
+	}
+	return runners, nil
+}
+
+// currentConfig returns the client configuration in effect
+func (c *Client) currentConfig() *ClientConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// PrepareUpdate builds the endpoints, rates and request settings of config
+// and returns a function that switches the client to them without restarting
+// it. Requests already in flight complete with their previous settings. Until
+// apply is called the client is unchanged, so a reload can give up on errors
+// elsewhere. Only one update may be prepared at a time.
+func (c *Client) PrepareUpdate(config *ClientConfig) (apply func(), err error) {
+	c.mu.Lock()
+	runners, err := c.newEndpointRunners(config, c.runners)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, runner := range c.runners {
+			close(runner.stop)
+		}
+		c.config = config
+		c.runners = runners
+
+		if c.runCtx != nil {
+			for _, runner := range runners {
+				go c.runEndpoint(c.runCtx, runner)
+			}
+		}
+	}, nil
 }
 
 // Run starts the HTTP client component
 func (c *Client) Run(ctx context.Context) error {
 	c.logger.Info("Starting HTTP client...")
+	c.started = time.Now()
+
+	config := c.currentConfig()
+	runners, err := c.newEndpointRunners(config, nil)
+	if err != nil {
+		return err
+	}
+
+	if config.Results != nil {
+		results, err := newResultWriter(config.Results)
+		if err != nil {
+			return err
+		}
+		defer results.Close()
+		c.results = results
+		c.logger.Info("Writing request results to %s", config.Results.File)
+	}
 
 	// Create a context specifically for request generation
 	var runCtx context.Context
 	var cancel context.CancelFunc
 
-	if c.config.Timeout > 0 {
-		c.logger.Info("Client configured to run for %v", c.config.Timeout)
+	if config.Timeout > 0 {
+		c.logger.Info("Client configured to run for %v", config.Timeout)
 		// Derive runCtx from the main ctx, but with a timeout
-		runCtx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		runCtx, cancel = context.WithTimeout(ctx, config.Timeout)
 		defer cancel()
 
 		// Monitor timeout to log when we stop sending requests
@@ -52,7 +212,12 @@ func (c *Client) Run(ctx context.Context) error {
 			<-runCtx.Done()
 			// If runCtx is done but main ctx is not, it means we hit the timeout
 			if ctx.Err() == nil {
-				c.logger.Info("Client timeout reached. Stopping requests but keeping process alive...")
+				if len(config.Thresholds) > 0 {
+					c.logger.Info("Client timeout reached. Stopping requests and checking thresholds...")
+				} else {
+					c.logger.Info("Client timeout reached. Stopping requests but keeping process alive...")
+					c.writeReport()
+				}
 			}
 		}()
 	} else {
@@ -62,22 +227,75 @@ func (c *Client) Run(ctx context.Context) error {
 
 	// Start a goroutine for each endpoint to handle rate limiting independently
 	// They will use runCtx, so they stop when timeout is reached
-	for _, endpoint := range c.config.Endpoints {
-		go c.runEndpoint(runCtx, endpoint)
+	c.mu.Lock()
+	c.runCtx = runCtx
+	c.runners = runners
+	for _, runner := range runners {
+		go c.runEndpoint(runCtx, runner)
+	}
+	c.mu.Unlock()
+
+	// With thresholds the run ends at the timeout; otherwise wait for MAIN
+	// context cancellation (signal), not the timeout
+	if len(config.Thresholds) > 0 {
+		select {
+		case <-runCtx.Done():
+			if ctx.Err() == nil {
+				report := c.writeReport()
+				return &runCompleteError{exitCode: evaluateThresholds(c.currentConfig().Thresholds, report, c.logger)}
+			}
+		case <-ctx.Done():
+		}
 	}
 
-	// Wait for MAIN context cancellation (signal), not the timeout
 	<-ctx.Done()
 	c.logger.Info("Client shutting down...")
+	c.writeReport()
 	return ctx.Err()
 }
 
+// writeReport writes the end-of-run summary, once, when the timeout is reached
+// or the client shuts down
+func (c *Client) writeReport() *RunReport {
+	c.reportOnce.Do(func() {
+		c.report = c.stats.report(c.started)
+		if err := writeReport(c.report, c.currentConfig().Report); err != nil {
+			c.logger.Error("Failed to write run summary: %v", err)
+		}
+	})
+	return c.report
+}
+
+// writeResult appends an attempt to the result stream, if enabled
+func (c *Client) writeResult(result *requestResult, err error) {
+	if c.results == nil {
+		return
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if err := c.results.write(result); err != nil {
+		c.logger.Warn("Failed to write request result: %v", err)
+	}
+}
+
+// recordError counts a failed attempt in the run summary. Attempts cut short by
+// the end of the run are left out.
+func (c *Client) recordError(ctx context.Context, runner *endpointRunner, errorType string) {
+	if ctx.Err() == nil {
+		runner.stats.recordError(errorType)
+	}
+}
+
 // runEndpoint handles requests for a single endpoint with rate limiting
-func (c *Client) runEndpoint(ctx context.Context, endpoint EndpointConfig) {
+func (c *Client) runEndpoint(ctx context.Context, runner *endpointRunner) {
+	endpoint := runner.config
+	config := c.currentConfig()
+
 	// Create semaphore to limit concurrent requests (only if limit is set)
 	var semaphore chan struct{}
-	if c.config.MaxConcurrentRequests > 0 {
-		semaphore = make(chan struct{}, c.config.MaxConcurrentRequests)
+	if config.MaxConcurrentRequests > 0 {
+		semaphore = make(chan struct{}, config.MaxConcurrentRequests)
 	}
 	
 	// Helper function to launch request with optional semaphore control
@@ -87,11 +305,11 @@ func (c *Client) runEndpoint(ctx context.Context, endpoint EndpointConfig) {
 			go func() {
 				semaphore <- struct{}{}        // Acquire semaphore
 				defer func() { <-semaphore }() // Release semaphore
-				c.makeRequest(ctx, endpoint)
+				c.makeRequest(ctx, runner)
 			}()
 		} else {
 			// Unlimited concurrency
-			go c.makeRequest(ctx, endpoint)
+			go c.makeRequest(ctx, runner)
 		}
 	}
 	
@@ -101,9 +319,9 @@ func (c *Client) runEndpoint(ctx context.Context, endpoint EndpointConfig) {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
-		if c.config.MaxConcurrentRequests > 0 {
+		if config.MaxConcurrentRequests > 0 {
 			c.logger.Info("Endpoint [%s] configured for %.2f requests/second (max %d concurrent)", 
-				endpoint.Name, endpoint.RequestsPerSecond, c.config.MaxConcurrentRequests)
+				endpoint.Name, endpoint.RequestsPerSecond, config.MaxConcurrentRequests)
 		} else {
 			c.logger.Info("Endpoint [%s] configured for %.2f requests/second (unlimited concurrent)", 
 				endpoint.Name, endpoint.RequestsPerSecond)
@@ -113,21 +331,23 @@ func (c *Client) runEndpoint(ctx context.Context, endpoint EndpointConfig) {
 			select {
 			case <-ctx.Done():
 				return
+			case <-runner.stop:
+				return
 			case <-ticker.C:
 				launchRequest()
 			}
 		}
 	} else {
 		// Interval-based mode: use global interval
-		ticker := time.NewTicker(c.config.Interval)
+		ticker := time.NewTicker(config.Interval)
 		defer ticker.Stop()
 
-		if c.config.MaxConcurrentRequests > 0 {
+		if config.MaxConcurrentRequests > 0 {
 			c.logger.Info("Endpoint [%s] configured with interval %v (max %d concurrent)", 
-				endpoint.Name, c.config.Interval, c.config.MaxConcurrentRequests)
+				endpoint.Name, config.Interval, config.MaxConcurrentRequests)
 		} else {
 			c.logger.Info("Endpoint [%s] configured with interval %v (unlimited concurrent)", 
-				endpoint.Name, c.config.Interval)
+				endpoint.Name, config.Interval)
 		}
 
 		// Make initial request immediately
@@ -137,6 +357,8 @@ func (c *Client) runEndpoint(ctx context.Context, endpoint EndpointConfig) {
 			select {
 			case <-ctx.Done():
 				return
+			case <-runner.stop:
+				return
 			case <-ticker.C:
 				launchRequest()
 			}
@@ -145,17 +367,46 @@ func (c *Client) runEndpoint(ctx context.Context, endpoint EndpointConfig) {
 }
 
 // makeRequest executes a single HTTP request with diagnostics
-func (c *Client) makeRequest(ctx context.Context, endpoint EndpointConfig) {
+func (c *Client) makeRequest(ctx context.Context, runner *endpointRunner) {
+	endpoint := runner.config
 	attempts := 0
 	maxAttempts := endpoint.Retries + 1
 
+	// Every attempt sends the same templated headers and body
+	var prepared *preparedRequest
+	if endpoint.Type != "tcp" {
+		var err error
+		if prepared, err = runner.requests.next(); err != nil {
+			c.logger.Error("Request failed [%s]: preparing request: %v", endpoint.Name, err)
+			runner.stats.recordRequest(false, 0)
+			return
+		}
+	}
+
 	for attempts < maxAttempts {
 		attempts++
 
-		if err := c.executeRequest(ctx, endpoint, attempts); err != nil {
+		var err error
+		if endpoint.Type == "tcp" {
+			err = c.executeTCPProbe(ctx, runner, attempts)
+		} else {
+			err = c.executeRequest(ctx, runner, attempts, prepared)
+		}
+		if err != nil {
 			c.logger.Error("Request failed [%s] (attempt %d/%d): %v",
 				endpoint.Name, attempts, maxAttempts, err)
 
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Failed expectations are only retried when asked for
+			var expectErr *expectationError
+			if errors.As(err, &expectErr) && !expectErr.retry {
+				runner.stats.recordRequest(false, attempts-1)
+				return
+			}
+
 			// Track retry metrics
 			if attempts > 1 {
 				c.metrics.ClientRetries.WithLabelValues(endpoint.Name, endpoint.Method).Inc()
@@ -165,54 +416,123 @@ func (c *Client) makeRequest(ctx context.Context, endpoint EndpointConfig) {
 				time.Sleep(time.Second * time.Duration(attempts))
 				continue
 			}
-		} else {
-			break
 		}
+		runner.stats.recordRequest(err == nil, attempts-1)
+		return
 	}
 }
 
 // executeRequest performs the actual HTTP request with detailed diagnostics
-func (c *Client) executeRequest(ctx context.Context, endpoint EndpointConfig, attempt int) error {
+func (c *Client) executeRequest(ctx context.Context, runner *endpointRunner, attempt int, prepared *preparedRequest) (err error) {
+	endpoint := runner.config
 	start := time.Now()
 
 	// Create request
-	var bodyReader io.Reader
-	if endpoint.Body != "" {
-		bodyReader = bytes.NewBufferString(endpoint.Body)
+	bodyReader, bodySize, err := prepared.body()
+	if err != nil {
+		return fmt.Errorf("failed to open body: %w", err)
+	}
+	if closer, ok := bodyReader.(io.Closer); ok && bodySize == 0 {
+		closer.Close()
+	}
+	if bodySize == 0 {
+		bodyReader = http.NoBody
 	}
-
 	req, err := http.NewRequestWithContext(ctx, endpoint.Method, endpoint.URL, bodyReader)
 	if err != nil {
+		if closer, ok := bodyReader.(io.Closer); ok {
+			closer.Close()
+		}
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	// Streamed bodies are sent with their length instead of chunked
+	req.ContentLength = bodySize
 
 	// Add headers
-	for key, value := range endpoint.Headers {
+	if prepared.contentType != "" {
+		req.Header.Set("Content-Type", prepared.contentType)
+	}
+	for key, value := range prepared.headers {
 		req.Header.Set(key, value)
 	}
 
 	// Add trace for detailed diagnostics
 	var dnsStart, connectStart, tlsStart time.Time
 	var dnsDuration, connectDuration, tlsDuration, ttfbDuration time.Duration
+	var tlsState *tls.ConnectionState
+	var tlsErr error
+	var connInfo httptrace.GotConnInfo
+	var connAge time.Duration
+	var dnsInfo *httptrace.DNSDoneInfo
+	var connectedAddr string
+
+	// Record the attempt in the result stream once it completes
+	result := &requestResult{
+		Timestamp: start,
+		Endpoint:  endpoint.Name,
+		Type:      endpoint.Type,
+		Method:    endpoint.Method,
+		URL:       endpoint.URL,
+		Attempt:   attempt,
+		BytesSent: bodySize,
+	}
+	defer func() {
+		result.DNSMs = milliseconds(dnsDuration)
+		result.TCPMs = milliseconds(connectDuration)
+		result.TLSMs = milliseconds(tlsDuration)
+		result.TTFBMs = milliseconds(ttfbDuration)
+		result.TotalMs = milliseconds(time.Since(start))
+		if dnsInfo != nil && dnsInfo.Err == nil {
+			result.ResolvedAddrs = ipStrings(dnsInfo.Addrs)
+		}
+		result.setConn(connInfo.Conn)
+		result.Reused = connInfo.Reused
+		if connInfo.Reused {
+			result.ConnAgeMs = milliseconds(connAge)
+			result.IdleMs = milliseconds(connInfo.IdleTime)
+		}
+		c.writeResult(result, err)
+	}()
 
 	trace := &httptrace.ClientTrace{
 		DNSStart: func(_ httptrace.DNSStartInfo) {
 			dnsStart = time.Now()
 		},
-		DNSDone: func(_ httptrace.DNSDoneInfo) {
+		DNSDone: func(info httptrace.DNSDoneInfo) {
 			dnsDuration = time.Since(dnsStart)
+			dnsInfo = &info
+			c.recordDNS(endpoint, info)
 		},
 		ConnectStart: func(_, _ string) {
 			connectStart = time.Now()
 		},
-		ConnectDone: func(_, _ string, _ error) {
+		ConnectDone: func(_, addr string, err error) {
 			connectDuration = time.Since(connectStart)
+			if err == nil {
+				connectedAddr = addr
+				c.recordConnectedAddress(endpoint, addr)
+			}
 		},
 		TLSHandshakeStart: func() {
 			tlsStart = time.Now()
 		},
-		TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
 			tlsDuration = time.Since(tlsStart)
+			if err != nil {
+				tlsErr = err
+			} else {
+				tlsState = &state
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			connInfo = info
+			connAge = runner.conns.gotConn(info)
+		},
+		PutIdleConn: func(err error) {
+			runner.conns.putIdleConn(err)
+			if err != nil && c.logger.verbose {
+				c.logger.Debug("  Connection for [%s] not kept for reuse: %v", endpoint.Name, err)
+			}
 		},
 		GotFirstResponseByte: func() {
 			ttfbDuration = time.Since(start)
@@ -224,26 +544,47 @@ func (c *Client) executeRequest(ctx context.Context, endpoint EndpointConfig, at
 	// Execute request
 	c.logger.Info("→ [%s] %s %s (attempt %d)", endpoint.Name, endpoint.Method, endpoint.URL, attempt)
 
-	resp, err := c.client.Do(req)
+	resp, err := runner.client.Do(req)
 	if err != nil {
 		// Track error metrics
+		if dnsInfo != nil && dnsInfo.Err != nil {
+			errorType := classifyDNSError(dnsInfo.Err)
+			c.metrics.ClientRequestErrors.WithLabelValues(endpoint.Name, endpoint.Method, errorType).Inc()
+			c.recordError(ctx, runner, errorType)
+			result.ErrorClass = errorType
+			return fmt.Errorf("DNS lookup failed (%s): %w", errorType, err)
+		}
+		if tlsErr != nil {
+			errorType := classifyClientTLSError(tlsErr)
+			c.metrics.ClientRequestErrors.WithLabelValues(endpoint.Name, endpoint.Method, errorType).Inc()
+			c.recordError(ctx, runner, errorType)
+			result.ErrorClass = errorType
+			return fmt.Errorf("TLS handshake failed (%s): %w", errorType, err)
+		}
 		c.metrics.ClientRequestErrors.WithLabelValues(endpoint.Name, endpoint.Method, "request_failed").Inc()
+		c.recordError(ctx, runner, "request_failed")
+		result.ErrorClass = "request_failed"
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	result.Status = resp.StatusCode
+	result.Protocol = resp.Proto
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
+	result.BytesReceived = int64(len(body))
 	if err != nil {
 		// Track error metrics
 		c.metrics.ClientRequestErrors.WithLabelValues(endpoint.Name, endpoint.Method, "read_body_failed").Inc()
+		c.recordError(ctx, runner, "read_body_failed")
+		result.ErrorClass = "read_body_failed"
 		return fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	totalDuration := time.Since(start)
 
 	// Track metrics
-	c.metrics.ClientRequestsTotal.WithLabelValues(endpoint.Name, endpoint.Method, fmt.Sprintf("%d", resp.StatusCode)).Inc()
+	c.metrics.ClientRequestsTotal.WithLabelValues(endpoint.Name, endpoint.Method, fmt.Sprintf("%d", resp.StatusCode), resp.Proto).Inc()
 	c.metrics.ClientRequestDuration.WithLabelValues(endpoint.Name, endpoint.Method).Observe(totalDuration.Seconds())
 
 	if dnsDuration > 0 {
@@ -258,19 +599,41 @@ func (c *Client) executeRequest(ctx context.Context, endpoint EndpointConfig, at
 	if ttfbDuration > 0 {
 		c.metrics.ClientTTFBDuration.WithLabelValues(endpoint.Name).Observe(ttfbDuration.Seconds())
 	}
+	if tlsState != nil {
+		c.recordTLSState(endpoint, tlsState)
+	}
+	runner.stats.observe(phaseTotal, totalDuration)
+	for phase, d := range map[string]time.Duration{phaseDNS: dnsDuration, phaseTCP: connectDuration, phaseTLS: tlsDuration, phaseTTFB: ttfbDuration} {
+		if d > 0 {
+			runner.stats.observe(phase, d)
+		}
+	}
 
 	// Log response
-	c.logger.Info("← [%s] Status: %d, Size: %d bytes, Duration: %v",
-		endpoint.Name, resp.StatusCode, len(body), totalDuration)
+	c.logger.Info("← [%s] Status: %d, Protocol: %s, Size: %d bytes, Duration: %v",
+		endpoint.Name, resp.StatusCode, resp.Proto, len(body), totalDuration)
+
+	// Evaluate response assertions
+	var expectErr error
+	if runner.expect != nil {
+		if failures := runner.expect.check(resp, body, totalDuration); len(failures) > 0 {
+			for _, failure := range failures {
+				c.metrics.ClientRequestErrors.WithLabelValues(endpoint.Name, endpoint.Method, failure.rule).Inc()
+				c.recordError(ctx, runner, failure.rule)
+			}
+			expectErr = &expectationError{failures: failures, retry: endpoint.Expect.Retry}
+			result.ErrorClass = failures[0].rule
+		}
+	}
 
 	// Log detailed diagnostics if verbose
 	if c.logger.verbose {
 		c.logger.Debug("  Diagnostics for [%s]:", endpoint.Name)
-		if dnsDuration > 0 {
-			c.logger.Debug("    DNS Lookup: %v", dnsDuration)
+		if dnsInfo != nil {
+			c.logger.Debug("    DNS Lookup: %v, %d addresses %v", dnsDuration, len(dnsInfo.Addrs), ipStrings(dnsInfo.Addrs))
 		}
 		if connectDuration > 0 {
-			c.logger.Debug("    TCP Connect: %v", connectDuration)
+			c.logger.Debug("    TCP Connect: %v to %s", connectDuration, connectedAddr)
 		}
 		if tlsDuration > 0 {
 			c.logger.Debug("    TLS Handshake: %v", tlsDuration)
@@ -279,6 +642,17 @@ func (c *Client) executeRequest(ctx context.Context, endpoint EndpointConfig, at
 			c.logger.Debug("    Time to First Byte: %v", ttfbDuration)
 		}
 		c.logger.Debug("    Total Time: %v", totalDuration)
+		if connInfo.Conn != nil {
+			if connInfo.Reused {
+				c.logger.Debug("    Connection: reused %s -> %s (age %v, idle %v)",
+					connInfo.Conn.LocalAddr(), connInfo.Conn.RemoteAddr(), connAge.Round(time.Millisecond), connInfo.IdleTime.Round(time.Millisecond))
+			} else {
+				c.logger.Debug("    Connection: new %s -> %s", connInfo.Conn.LocalAddr(), connInfo.Conn.RemoteAddr())
+			}
+		}
+		if resp.TLS != nil {
+			c.logTLSState(resp.TLS)
+		}
 
 		// Log response headers
 		c.logger.Debug("  Response Headers:")
@@ -298,5 +672,32 @@ func (c *Client) executeRequest(ctx context.Context, endpoint EndpointConfig, at
 		}
 	}
 
-	return nil
+	return expectErr
+}
+
+// recordTLSState exports the negotiated parameters and peer certificate expiry of a new TLS connection
+func (c *Client) recordTLSState(endpoint EndpointConfig, state *tls.ConnectionState) {
+	c.metrics.ClientTLSInfo.DeletePartialMatch(prometheus.Labels{"endpoint": endpoint.Name})
+	c.metrics.ClientTLSInfo.WithLabelValues(endpoint.Name, tls.VersionName(state.Version),
+		tls.CipherSuiteName(state.CipherSuite), state.NegotiatedProtocol).Set(1)
+
+	c.metrics.ClientTLSCertExpiryDays.DeletePartialMatch(prometheus.Labels{"endpoint": endpoint.Name})
+	for i, cert := range state.PeerCertificates {
+		c.metrics.ClientTLSCertExpiryDays.WithLabelValues(endpoint.Name, fmt.Sprintf("%d", i),
+			cert.Subject.String(), cert.Issuer.String()).Set(daysUntilExpiry(cert))
+	}
+}
+
+// logTLSState logs the negotiated TLS parameters and the peer certificate chain
+func (c *Client) logTLSState(state *tls.ConnectionState) {
+	alpn := state.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "none"
+	}
+	c.logger.Debug("    TLS: %s, %s, ALPN %s, resumed %v",
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), alpn, state.DidResume)
+	for i, cert := range state.PeerCertificates {
+		c.logger.Debug("    Peer Certificate %d: %s (issuer: %s, expires in %.1f days)",
+			i, cert.Subject, cert.Issuer, daysUntilExpiry(cert))
+	}
 }
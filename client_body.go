@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// requestBuilder prepares the headers and body of each request of a client
+// endpoint from its body, body_file, generate, multipart and template settings
+type requestBuilder struct {
+	config    EndpointConfig
+	generated *bodyGenerator                // nil without generate
+	body      *template.Template            // Body or body_file template, nil when not templated
+	headers   map[string]*template.Template // Header templates
+	fields    map[string]*template.Template // Multipart field templates
+	data      *dataFile                     // nil without data_file
+	requests  atomic.Uint64
+	random    *lockedRand // Draws of randInt and randString
+}
+
+// preparedRequest is what every attempt of one request sends
+type preparedRequest struct {
+	headers     map[string]string
+	contentType string // Set when the body needs a Content-Type the headers do not give
+	// body returns a new body for each attempt and its size
+	body func() (io.Reader, int64, error)
+}
+
+// requestTemplateData is the data available to client templates as "."
+type requestTemplateData struct {
+	Endpoint string
+	Seq      uint64            // Number of this request to the endpoint, starting at 1
+	Time     time.Time         // When the request was prepared
+	Data     map[string]string // Row of data_file by column name
+}
+
+// newRequestBuilder loads the files and parses the templates of an endpoint
+func newRequestBuilder(endpoint EndpointConfig) (*requestBuilder, error) {
+	b := &requestBuilder{config: endpoint}
+	if endpoint.Generate != nil {
+		b.generated = newBodyGenerator(endpoint.Generate, "body "+endpoint.Name)
+	}
+	if endpoint.DataFile != "" {
+		data, err := loadDataFile(endpoint.DataFile, endpoint.DataOrder == "random", "data "+endpoint.Name)
+		if err != nil {
+			return nil, fmt.Errorf("data_file: %w", err)
+		}
+		b.data = data
+	}
+	if !endpoint.Template {
+		return b, nil
+	}
+
+	text := endpoint.Body
+	if endpoint.BodyFile != "" {
+		content, err := os.ReadFile(endpoint.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("body_file: %w", err)
+		}
+		text = string(content)
+	}
+	b.random = newLockedRand("request template " + endpoint.Name)
+	funcs := newTemplateFuncs(b.random)
+	var err error
+	if b.body, err = template.New("body").Funcs(funcs).Parse(text); err != nil {
+		return nil, err
+	}
+	if b.headers, err = parseTemplates(endpoint.Headers, funcs); err != nil {
+		return nil, err
+	}
+	if endpoint.Multipart != nil {
+		if b.fields, err = parseTemplates(endpoint.Multipart.Fields, funcs); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// parseTemplates parses the values of a map, naming each template after its key
+func parseTemplates(values map[string]string, funcs template.FuncMap) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(values))
+	for key, value := range values {
+		tmpl, err := template.New(key).Funcs(funcs).Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		templates[key] = tmpl
+	}
+	return templates, nil
+}
+
+// next prepares the next request. Templates are rendered here rather than per
+// attempt, so retries send the same IDs and data row.
+func (b *requestBuilder) next() (*preparedRequest, error) {
+	cfg := b.config
+	p := &preparedRequest{headers: cfg.Headers}
+	body := []byte(cfg.Body)
+	var fields map[string]string
+	if cfg.Multipart != nil {
+		fields = cfg.Multipart.Fields
+	}
+
+	if b.body != nil {
+		data := &requestTemplateData{Endpoint: cfg.Name, Seq: b.requests.Add(1), Time: time.Now()}
+		if b.data != nil {
+			data.Data = b.data.next()
+		}
+		var err error
+		if p.headers, err = executeTemplates(b.headers, data); err != nil {
+			return nil, err
+		}
+		if fields, err = executeTemplates(b.fields, data); err != nil {
+			return nil, err
+		}
+		var rendered bytes.Buffer
+		if err := b.body.Execute(&rendered, data); err != nil {
+			return nil, err
+		}
+		body = rendered.Bytes()
+	}
+
+	switch {
+	case b.generated != nil:
+		p.body = func() (io.Reader, int64, error) {
+			body, size := b.generated.next()
+			return body, size, nil
+		}
+	case cfg.Multipart != nil:
+		p.body, p.contentType = multipartBody(cfg.Multipart, fields)
+	case cfg.BodyFile != "" && b.body == nil:
+		p.body = fileBody(cfg.BodyFile)
+	default:
+		p.body = func() (io.Reader, int64, error) {
+			if len(body) == 0 {
+				return nil, 0, nil
+			}
+			return bytes.NewReader(body), int64(len(body)), nil
+		}
+	}
+	return p, nil
+}
+
+// executeTemplates renders each template of a map with data
+func executeTemplates(templates map[string]*template.Template, data interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(templates))
+	var out strings.Builder
+	for key, tmpl := range templates {
+		out.Reset()
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		values[key] = out.String()
+	}
+	return values, nil
+}
+
+// fileBody streams the file at path, opened again for each attempt so changes
+// to it are picked up
+func fileBody(path string) func() (io.Reader, int64, error) {
+	return func() (io.Reader, int64, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	}
+}
+
+// multipartBody streams a multipart/form-data body of fields and the files of
+// cfg, and returns its content type. Files are read while the body is sent.
+func multipartBody(cfg *MultipartConfig, fields map[string]string) (func() (io.Reader, int64, error), string) {
+	boundary := multipart.NewWriter(nil).Boundary()
+	body := func() (io.Reader, int64, error) {
+		// Measure the body without the file contents, so it is not sent chunked
+		counter := &countingWriter{}
+		mw := multipart.NewWriter(counter)
+		mw.SetBoundary(boundary)
+		if err := writeMultipart(mw, cfg, fields, false); err != nil {
+			return nil, 0, err
+		}
+		size := counter.n
+		for _, file := range cfg.Files {
+			info, err := os.Stat(file.File)
+			if err != nil {
+				return nil, 0, err
+			}
+			size += info.Size()
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			mw := multipart.NewWriter(pw)
+			mw.SetBoundary(boundary)
+			pw.CloseWithError(writeMultipart(mw, cfg, fields, true))
+		}()
+		return pr, size, nil
+	}
+	return body, "multipart/form-data; boundary=" + boundary
+}
+
+// writeMultipart writes the fields, sorted by name, and the file parts to mw,
+// with the file contents when withFiles is set
+func writeMultipart(mw *multipart.Writer, cfg *MultipartConfig, fields map[string]string, withFiles bool) error {
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		if err := mw.WriteField(name, fields[name]); err != nil {
+			return err
+		}
+	}
+	for _, file := range cfg.Files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(file.Field), quoteEscaper.Replace(file.Filename)))
+		header.Set("Content-Type", file.ContentType)
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if withFiles {
+			if err := copyFile(part, file.File); err != nil {
+				return err
+			}
+		}
+	}
+	return mw.Close()
+}
+
+// quoteEscaper escapes quoted strings of Content-Disposition headers, like mime/multipart
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// copyFile copies the file at path to w
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// dataFile holds the rows of a CSV file handed out to request templates
+type dataFile struct {
+	rows   []map[string]string
+	random *lockedRand // Picks rows at random; nil for file order
+	served atomic.Uint64
+}
+
+// loadDataFile reads a CSV file whose first line names the columns
+func loadDataFile(path string, random bool, stream string) (*dataFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s needs a header line and at least one row", path)
+	}
+	d := &dataFile{}
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(record))
+		for i, column := range records[0] {
+			row[column] = record[i]
+		}
+		d.rows = append(d.rows, row)
+	}
+	if random {
+		d.random = newLockedRand(stream)
+	}
+	return d, nil
+}
+
+// next returns the next row, in file order starting over at the end, or at random
+func (d *dataFile) next() map[string]string {
+	if d.random != nil {
+		return d.rows[d.random.Uint64()%uint64(len(d.rows))]
+	}
+	return d.rows[(d.served.Add(1)-1)%uint64(len(d.rows))]
+}
@@ -2,7 +2,14 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -14,6 +21,7 @@ type Config struct {
 	Client  *ClientConfig  `yaml:"client,omitempty"`
 	Backend *BackendConfig `yaml:"backend,omitempty"`
 	Logging LoggingConfig  `yaml:"logging"`
+	Seed    uint64         `yaml:"seed,omitempty"` // Seed of all fault decisions, for reproducible runs (0 = random)
 }
 
 // ClientConfig holds client-specific configuration
@@ -23,36 +31,391 @@ type ClientConfig struct {
 	RequestTimeout         time.Duration    `yaml:"request_timeout,omitempty"` // Per-request timeout
 	Interval               time.Duration    `yaml:"interval"`                 // Time between requests
 	MaxConcurrentRequests  int              `yaml:"max_concurrent_requests,omitempty"` // Max concurrent requests per endpoint (0 = unlimited)
+	Report                 *ReportConfig    `yaml:"report,omitempty"`                  // End-of-run summary settings
+	Thresholds             []string         `yaml:"thresholds,omitempty"`              // SLOs checked when timeout elapses, e.g. "p99 < 300ms"
+	Results                *ResultsConfig   `yaml:"results,omitempty"`                 // Per-request JSONL result stream
+}
+
+// ResultsConfig controls the per-request result stream
+type ResultsConfig struct {
+	File       string `yaml:"file"`                  // JSON lines file, one line per request attempt
+	MaxSizeMB  int    `yaml:"max_size_mb,omitempty"` // Rotate when the file exceeds this size (default: 100)
+	MaxBackups int    `yaml:"max_backups,omitempty"` // Rotated files to keep as file.1, file.2, ... (default: 5)
+}
+
+// ReportConfig controls the summary written when the client run ends
+type ReportConfig struct {
+	Format string `yaml:"format,omitempty"` // table (default), json or markdown
+	Output string `yaml:"output,omitempty"` // File path (default: stdout)
 }
 
 // EndpointConfig defines an HTTP endpoint to call
 type EndpointConfig struct {
-	Name             string            `yaml:"name"`
-	URL              string            `yaml:"url"`
-	Method           string            `yaml:"method"`
-	Headers          map[string]string `yaml:"headers,omitempty"`
-	Body             string            `yaml:"body,omitempty"`
-	Retries          int               `yaml:"retries"`
-	RequestsPerSecond float64          `yaml:"requests_per_second,omitempty"` // Rate limit: N requests per second
+	Name              string               `yaml:"name"`
+	Type              string               `yaml:"type,omitempty"` // http (default) or tcp
+	URL               string               `yaml:"url"`            // http(s)://... or tcp://host:port
+	Method            string               `yaml:"method"`
+	Headers           map[string]string    `yaml:"headers,omitempty"`
+	Body              string               `yaml:"body,omitempty"`
+	Retries           int                  `yaml:"retries"`
+	RequestsPerSecond float64              `yaml:"requests_per_second,omitempty"` // Rate limit: N requests per second
+	TLS               *TLSClientConfig     `yaml:"tls,omitempty"`                 // TLS settings for https:// URLs
+	TCP               *TCPProbeConfig      `yaml:"tcp,omitempty"`                 // Raw TCP probe settings (type: tcp)
+	Expect            *ExpectConfig        `yaml:"expect,omitempty"`              // Rules a response must satisfy to count as a success
+	Connection        *ConnectionConfig    `yaml:"connection,omitempty"`          // Connection pooling and keep-alive settings
+	DNS               *DNSConfig           `yaml:"dns,omitempty"`                 // Resolver and address overrides
+	Protocol          string               `yaml:"protocol,omitempty"`            // auto (default), http1, h2 or h2c
+	Shaping           *ShapingConfig       `yaml:"shaping,omitempty"`             // Bandwidth, latency and stalls on the endpoint's connections
+	Generate          *GeneratedBodyConfig `yaml:"generate,omitempty"`            // Send a generated request body instead of body
+	BodyFile          string               `yaml:"body_file,omitempty"`           // Send the contents of this file as the body
+	Multipart         *MultipartConfig     `yaml:"multipart,omitempty"`           // Send a multipart/form-data upload as the body
+	Template          bool                 `yaml:"template,omitempty"`            // Render headers, body, body_file and multipart fields as Go templates per request
+	DataFile          string               `yaml:"data_file,omitempty"`           // CSV file whose rows templates read as .Data, one row per request
+	DataOrder         string               `yaml:"data_order,omitempty"`          // sequential (default, starting over at the end) or random
+}
+
+// ShapingConfig emulates a slow or lossy network on connections, like tc/netem.
+// Bandwidth caps apply to each direction separately.
+type ShapingConfig struct {
+	BytesPerSecond          int           `yaml:"bytes_per_second,omitempty"`           // Bandwidth per connection
+	AggregateBytesPerSecond int           `yaml:"aggregate_bytes_per_second,omitempty"` // Bandwidth shared by all connections
+	Latency                 time.Duration `yaml:"latency,omitempty"`                    // Added to every read and write
+	Jitter                  time.Duration `yaml:"jitter,omitempty"`                     // Random extra latency, up to this much
+	StallPercent            float64       `yaml:"stall_percent,omitempty"`              // Percentage of reads and writes that stall (0-100)
+	StallDuration           time.Duration `yaml:"stall_duration,omitempty"`             // How long a stall lasts (default: 1s)
+}
+
+// DNSConfig controls how an endpoint's host name is resolved
+type DNSConfig struct {
+	Resolve  []string      `yaml:"resolve,omitempty"`   // Overrides like curl --resolve: "host:port:addr[,addr...]"
+	Server   string        `yaml:"server,omitempty"`    // DNS server instead of /etc/resolv.conf, e.g. 172.30.0.10:53
+	IPFamily string        `yaml:"ip_family,omitempty"` // dual (default), ipv4 or ipv6
+	Timeout  time.Duration `yaml:"timeout,omitempty"`   // Timeout of each query to server (default: 5s)
+}
+
+// ConnectionConfig controls connection pooling for an HTTP endpoint
+type ConnectionConfig struct {
+	DisableKeepAlive        bool          `yaml:"disable_keep_alive,omitempty"`         // Send "Connection: close" and use one connection per request
+	MaxIdleConns            int           `yaml:"max_idle_conns,omitempty"`             // Idle connections kept in the pool (default: 2)
+	IdleTimeout             time.Duration `yaml:"idle_timeout,omitempty"`               // Close pooled connections idle for longer (default: 90s)
+	MaxConnsPerHost         int           `yaml:"max_conns_per_host,omitempty"`         // Limit on open connections (0 = unlimited)
+	NewConnectionPerRequest bool          `yaml:"new_connection_per_request,omitempty"` // Close each connection after its response without announcing it
+}
+
+// ExpectConfig defines assertions on HTTP responses
+type ExpectConfig struct {
+	Status       []string      `yaml:"status,omitempty"`        // Allowed codes or ranges: "200", "2xx", "200-299"
+	Headers      []HeaderRule  `yaml:"headers,omitempty"`       // Required (or forbidden) response headers
+	BodyContains string        `yaml:"body_contains,omitempty"` // Substring the body must contain
+	BodyRegex    string        `yaml:"body_regex,omitempty"`    // Regular expression the body must match
+	JSON         []JSONRule    `yaml:"json,omitempty"`          // JSONPath equality checks on the body
+	MaxLatency   time.Duration `yaml:"max_latency,omitempty"`   // Slowest acceptable total request duration
+	MinBodySize  int           `yaml:"min_body_size,omitempty"` // Bytes
+	MaxBodySize  int           `yaml:"max_body_size,omitempty"` // Bytes (0 = no limit)
+	Retry        bool          `yaml:"retry,omitempty"`         // Retry (up to retries) when an expectation fails
+}
+
+// HeaderRule asserts the presence, absence or value of a response header
+type HeaderRule struct {
+	Name   string `yaml:"name"`
+	Regex  string `yaml:"regex,omitempty"`  // Value must match (multiple values are joined with ", ")
+	Absent bool   `yaml:"absent,omitempty"` // Header must not be present
+}
+
+// JSONRule asserts the value at a JSONPath such as $.items[0].id
+type JSONRule struct {
+	Path   string      `yaml:"path"`
+	Equals interface{} `yaml:"equals"`
+}
+
+// TCPProbeConfig controls what a raw TCP probe does once connected
+type TCPProbeConfig struct {
+	Payload      string        `yaml:"payload,omitempty"`        // Written right after connecting
+	ExpectRegex  string        `yaml:"expect_regex,omitempty"`   // Received data must match before the read timeout
+	ReadTimeout  time.Duration `yaml:"read_timeout,omitempty"`   // How long to wait for data or a close (default: 5s)
+	MaxReadBytes int           `yaml:"max_read_bytes,omitempty"` // Stop reading after this many bytes (default: 65536)
+}
+
+// TLSClientConfig controls how the client verifies and presents certificates
+type TLSClientConfig struct {
+	CAFile             string   `yaml:"ca_file,omitempty"`              // CA bundle used instead of the system roots
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify,omitempty"` // Accept any server certificate
+	CertFile           string   `yaml:"cert_file,omitempty"`            // Client certificate for mutual TLS
+	KeyFile            string   `yaml:"key_file,omitempty"`
+	ServerName         string   `yaml:"server_name,omitempty"`          // SNI override (also used for verification)
+	MinVersion         string   `yaml:"min_version,omitempty"`          // 1.0, 1.1, 1.2 or 1.3
+	MaxVersion         string   `yaml:"max_version,omitempty"`          // 1.0, 1.1, 1.2 or 1.3
+	ALPN               []string `yaml:"alpn,omitempty"`                 // Offered application protocols, e.g. [h2, http/1.1]
 }
 
 // BackendConfig holds backend server configuration
 type BackendConfig struct {
-	Port      int               `yaml:"port"`
-	Endpoints []BackendEndpoint `yaml:"endpoints"`
+	Port          int                 `yaml:"port"`
+	Endpoints     []BackendEndpoint   `yaml:"endpoints"`
+	TLS           *TLSServerConfig    `yaml:"tls,omitempty"`            // Serve HTTPS instead of plain HTTP
+	TCPListeners  []TCPListenerConfig `yaml:"tcp_listeners,omitempty"`  // Raw TCP listeners with scripted behaviours
+	Admin         *AdminConfig        `yaml:"admin,omitempty"`          // Runtime admin API
+	DNSListeners  []DNSListenerConfig `yaml:"dns_listeners,omitempty"`  // DNS servers answering from a static zone
+	HTTP2         *HTTP2ServerConfig  `yaml:"http2,omitempty"`          // HTTP/2 and h2c settings of the HTTP listener
+	Shaping       *ShapingConfig      `yaml:"shaping,omitempty"`        // Bandwidth, latency and stalls on the HTTP listener
+	Default       *BackendEndpoint    `yaml:"default,omitempty"`        // Response to requests no endpoint matches (default: 404)
+	ProxyProtocol bool                `yaml:"proxy_protocol,omitempty"` // Expect a PROXY protocol v1 or v2 header on HTTP connections
+}
+
+// HTTP2ServerConfig controls the protocols offered by the backend HTTP listener
+type HTTP2ServerConfig struct {
+	Disable              bool          `yaml:"disable,omitempty"`                // Serve HTTP/1.1 only, also over TLS
+	H2C                  bool          `yaml:"h2c,omitempty"`                    // Accept HTTP/2 with prior knowledge without TLS
+	MaxConcurrentStreams int           `yaml:"max_concurrent_streams,omitempty"` // Streams per connection (default: 250)
+	StreamWindowSize     int           `yaml:"stream_window_size,omitempty"`     // Receive window per stream in bytes (default: 1MB)
+	ConnWindowSize       int           `yaml:"conn_window_size,omitempty"`       // Receive window per connection in bytes (default: 1MB)
+	MaxReadFrameSize     int           `yaml:"max_read_frame_size,omitempty"`    // Largest frame accepted in bytes (default: 1MB)
+	IdleTimeout          time.Duration `yaml:"idle_timeout,omitempty"`           // Send GOAWAY to (or close) connections idle for longer
+	MaxConnectionAge     time.Duration `yaml:"max_connection_age,omitempty"`     // Send GOAWAY (or Connection: close) on the first response after this age
+}
+
+// DNSListenerConfig defines a UDP and TCP DNS server with a static zone and fault injection
+type DNSListenerConfig struct {
+	Name            string        `yaml:"name"`
+	Port            int           `yaml:"port"`                       // Same port for UDP and TCP
+	Records         []DNSRecord   `yaml:"records"`
+	Delay           time.Duration `yaml:"delay,omitempty"`            // Added before every answer
+	DropPercent     float64       `yaml:"drop_percent,omitempty"`     // Percentage of queries left unanswered (0-100)
+	ServfailPercent float64       `yaml:"servfail_percent,omitempty"` // Percentage answered with SERVFAIL (0-100)
+	NXDomainPercent float64       `yaml:"nxdomain_percent,omitempty"` // Percentage answered with NXDOMAIN (0-100)
+	TruncatePercent float64       `yaml:"truncate_percent,omitempty"` // Percentage of UDP answers truncated to force TCP (0-100)
+	Rotate          bool          `yaml:"rotate,omitempty"`           // Rotate the order of multi-value answers on every query
+}
+
+// DNSRecord is a record served by a DNS listener
+type DNSRecord struct {
+	Name   string        `yaml:"name"`          // Host name, or a wildcard such as *.apps.example.com
+	Type   string        `yaml:"type"`          // A (default), AAAA, CNAME or TXT
+	Values []string      `yaml:"values"`
+	TTL    time.Duration `yaml:"ttl,omitempty"` // Default: 30s
+}
+
+// AdminConfig enables the authenticated admin API on a separate port
+type AdminConfig struct {
+	Port      int    `yaml:"port"`
+	Token     string `yaml:"token,omitempty"`      // Bearer token required on every request
+	TokenFile string `yaml:"token_file,omitempty"` // Read the token from a file (e.g. a mounted Secret)
+}
+
+// TCPListenerConfig defines a raw TCP listener with a scripted connection behaviour
+type TCPListenerConfig struct {
+	Name         string           `yaml:"name"`
+	Port         int              `yaml:"port"`
+	Behavior     string           `yaml:"behavior"`                // echo, banner, close, no_read, reset
+	Banner       string           `yaml:"banner,omitempty"`        // Sent by the banner behaviour before closing
+	HoldDuration time.Duration    `yaml:"hold_duration,omitempty"` // How long no_read keeps connections open (0 = until shutdown)
+	AcceptDelay  time.Duration    `yaml:"accept_delay,omitempty"`  // Pause before each accept() so the backlog fills up
+	DropPercent  float64          `yaml:"drop_percent,omitempty"`  // Percentage of connections closed right after accept (0-100)
+	IdlePercent  float64          `yaml:"idle_percent,omitempty"`  // Percentage of connections left idle (0-100)
+	IdleDuration time.Duration    `yaml:"idle_duration,omitempty"` // How long to keep idle connections open
+	TLS          *TLSServerConfig `yaml:"tls,omitempty"`           // Terminate TLS before applying the behaviour
+	Shaping      *ShapingConfig   `yaml:"shaping,omitempty"`       // Bandwidth, latency and stalls below TLS and the behaviour
+}
+
+// TLSServerConfig controls how a backend listener terminates TLS
+type TLSServerConfig struct {
+	CertFile     string           `yaml:"cert_file,omitempty"`
+	KeyFile      string           `yaml:"key_file,omitempty"`
+	AutoGenerate *AutoCertConfig  `yaml:"auto_generate,omitempty"`  // Generate a self-signed CA and certificates at startup
+	ClientAuth   string           `yaml:"client_auth,omitempty"`    // none, request, or require
+	ClientCAFile string           `yaml:"client_ca_file,omitempty"` // CA bundle used to verify client certificates
+	MinVersion   string           `yaml:"min_version,omitempty"`    // 1.0, 1.1, 1.2 or 1.3
+	MaxVersion   string           `yaml:"max_version,omitempty"`    // 1.0, 1.1, 1.2 or 1.3
+	CipherSuites []string         `yaml:"cipher_suites,omitempty"`  // IANA names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	SNI          []SNICertificate `yaml:"sni,omitempty"`            // Certificates selected by the client's server name
+}
+
+// AutoCertConfig describes the self-signed certificates generated at startup
+type AutoCertConfig struct {
+	Dir      string        `yaml:"dir"`                 // Directory where ca.crt, tls.crt, tls.key, client.crt and client.key are written
+	Hosts    []string      `yaml:"hosts,omitempty"`     // Server certificate SANs (default: localhost, hostname, 127.0.0.1, ::1)
+	ValidFor time.Duration `yaml:"valid_for,omitempty"` // Certificate lifetime (default: 8760h)
+	Expired  bool          `yaml:"expired,omitempty"`   // Issue a server certificate that has already expired
+}
+
+// SNICertificate maps TLS server names to a dedicated certificate
+type SNICertificate struct {
+	ServerNames []string `yaml:"server_names"`        // Exact names or wildcards such as *.example.com
+	CertFile    string   `yaml:"cert_file,omitempty"`
+	KeyFile     string   `yaml:"key_file,omitempty"`
+	Hosts       []string `yaml:"hosts,omitempty"`   // SANs when auto-generated (default: server_names)
+	Expired     bool     `yaml:"expired,omitempty"` // Auto-generate an already expired certificate
 }
 
 // BackendEndpoint defines how the server should respond to requests
 type BackendEndpoint struct {
-	Path            string            `yaml:"path"`
+	Name            string            `yaml:"name,omitempty"`            // Identifies the endpoint in the admin API when paths repeat
+	Path            string            `yaml:"path"`                      // Go path pattern, e.g. /users/{id} or /files/{name...}
 	Method          string            `yaml:"method"`
+	Methods         []string          `yaml:"methods,omitempty"`         // Further methods answered by the endpoint
+	Match           *RequestMatch     `yaml:"match,omitempty"`           // Host, query, header and body conditions
+	Priority        int               `yaml:"priority,omitempty"`        // Higher priorities are tried first, then configuration order
 	StatusCode      int               `yaml:"status_code"`
 	Headers         map[string]string `yaml:"headers,omitempty"`
 	Body            string            `yaml:"body,omitempty"`
+	Template        bool              `yaml:"template,omitempty"`        // Render body and header values as Go templates
+	Echo            *EchoConfig       `yaml:"echo,omitempty"`            // Answer with the details of the request as JSON instead of body
+	Generate        *GeneratedBodyConfig `yaml:"generate,omitempty"`       // Answer with a generated body instead of body
 	Delay           time.Duration     `yaml:"delay,omitempty"`      // Artificial delay
 	DropPercent     float64           `yaml:"drop_percent,omitempty"`    // Percentage of connections to drop (0-100)
 	IdlePercent     float64           `yaml:"idle_percent,omitempty"`    // Percentage of connections to leave idle (0-100)
 	IdleDuration    time.Duration     `yaml:"idle_duration,omitempty"`   // How long to keep idle connections open
+	HTTP2           *HTTP2FaultConfig `yaml:"http2,omitempty"`           // Stream-level faults for HTTP/2 requests
+	Stream          *BodyStreamConfig `yaml:"stream,omitempty"`          // Send the body slowly, in chunks or incompletely
+	DelayDistribution *DelayDistributionConfig `yaml:"delay_distribution,omitempty"` // Random delay instead of the fixed one
+	FaultSchedule     *FaultScheduleConfig     `yaml:"fault_schedule,omitempty"`     // Fixed sequence of outcomes instead of percentages
+	Scenario          *ScenarioConfig          `yaml:"scenario,omitempty"`           // Fail along a timeline: fail_first, warmup, flap, sequence or circuit
+}
+
+// ScenarioConfig makes an endpoint fail along a timeline, such as a slow
+// start or a flapping dependency, instead of at random per request
+type ScenarioConfig struct {
+	Type       string        `yaml:"type"`                  // fail_first, warmup, flap, sequence or circuit
+	FailWith   string        `yaml:"fail_with,omitempty"`   // A status code (default: 503), drop, idle or reset
+	RetryAfter time.Duration `yaml:"retry_after,omitempty"` // Retry-After header of failed responses
+	Requests   int           `yaml:"requests,omitempty"`    // fail_first: requests that fail; circuit: requests before it opens
+	Duration   time.Duration `yaml:"duration,omitempty"`    // warmup: how long requests fail; circuit: how long it stays open (0 = for good)
+	Healthy    time.Duration `yaml:"healthy,omitempty"`     // flap: length of the healthy windows
+	Unhealthy  time.Duration `yaml:"unhealthy,omitempty"`   // flap: length of the unhealthy windows
+	Sequence   []string      `yaml:"sequence,omitempty"`    // sequence: outcome of each request: ok, a status code, drop, idle or reset
+	Repeat     bool          `yaml:"repeat,omitempty"`      // sequence: start over at the end instead of answering normally
+	Key        string        `yaml:"key,omitempty"`         // Separate state per client_ip or per header:<name> (fail_first, sequence, circuit)
+
+	failure scenarioStep   // Compiled fail_with
+	steps   []scenarioStep // Compiled sequence
+}
+
+// FaultScheduleConfig makes the outcome of each request to an endpoint
+// deterministic. Set either pattern, or every and fault.
+type FaultScheduleConfig struct {
+	Pattern string `yaml:"pattern,omitempty"` // Outcomes repeated in order, e.g. "OKDIOK": OK (or .) normal, D drop, I idle, R reset
+	Every   int    `yaml:"every,omitempty"`   // Apply fault to every Nth request
+	Fault   string `yaml:"fault,omitempty"`   // drop, idle or reset
+
+	outcomes []string // Compiled pattern
+}
+
+// DelayDistributionConfig defines the distribution response delays are sampled from
+type DelayDistributionConfig struct {
+	Type   string        `yaml:"type"`             // uniform, normal, lognormal, exponential, pareto or empirical
+	Min    time.Duration `yaml:"min,omitempty"`    // Lower bound of uniform, floor of the others
+	Max    time.Duration `yaml:"max,omitempty"`    // Upper bound of uniform, cap of the others (0 = no cap)
+	Mean   time.Duration `yaml:"mean,omitempty"`   // normal and exponential
+	StdDev time.Duration `yaml:"stddev,omitempty"` // normal
+	Median time.Duration `yaml:"median,omitempty"` // lognormal
+	Sigma  float64       `yaml:"sigma,omitempty"`  // lognormal shape, the standard deviation of the log
+	Scale  time.Duration `yaml:"scale,omitempty"`  // pareto: the smallest delay
+	Alpha  float64       `yaml:"alpha,omitempty"`  // pareto shape, smaller means a heavier tail
+	File   string        `yaml:"file,omitempty"`   // empirical: CSV whose first column holds observed latencies
+	Seed   uint64        `yaml:"seed,omitempty"`   // Seed of the random generator (0 = random)
+
+	samples []time.Duration // Sorted latencies loaded from File
+}
+
+// GeneratedBodyConfig describes a body produced while it is sent instead of
+// written in the configuration
+type GeneratedBodyConfig struct {
+	Size             int                     `yaml:"size,omitempty"`              // Body size in bytes
+	SizeDistribution *SizeDistributionConfig `yaml:"size_distribution,omitempty"` // Draw the size of each body instead
+	Content          string                  `yaml:"content,omitempty"`           // pattern (default), random, json or ndjson
+	Pattern          string                  `yaml:"pattern,omitempty"`           // Text repeated by pattern bodies (default: a-z, 0-9 and a newline)
+	Records          int                     `yaml:"records,omitempty"`           // json/ndjson: number of records (default: as many as fit in the size)
+	RecordSize       int                     `yaml:"record_size,omitempty"`       // json/ndjson: bytes per record (default: 100)
+}
+
+// MultipartConfig describes a multipart/form-data request body
+type MultipartConfig struct {
+	Fields map[string]string `yaml:"fields,omitempty"` // Form values, sent sorted by name before the files
+	Files  []MultipartFile   `yaml:"files,omitempty"`  // Files uploaded in order, read while the body is sent
+}
+
+// MultipartFile is a file part of a multipart body
+type MultipartFile struct {
+	Field       string `yaml:"field"`                  // Form field name
+	File        string `yaml:"file"`                   // Path of the file to upload
+	Filename    string `yaml:"filename,omitempty"`     // File name sent to the server (default: base name of file)
+	ContentType string `yaml:"content_type,omitempty"` // Content type of the part (default: application/octet-stream)
+}
+
+// SizeDistributionConfig defines the distribution body sizes are drawn from,
+// with the parameters of DelayDistributionConfig in bytes
+type SizeDistributionConfig struct {
+	Type   string  `yaml:"type"`             // uniform, normal, lognormal, exponential or pareto
+	Min    int     `yaml:"min,omitempty"`    // Lower bound of uniform, floor of the others
+	Max    int     `yaml:"max,omitempty"`    // Upper bound of uniform, cap of the others (0 = no cap)
+	Mean   int     `yaml:"mean,omitempty"`   // normal and exponential
+	StdDev int     `yaml:"stddev,omitempty"` // normal
+	Median int     `yaml:"median,omitempty"` // lognormal
+	Sigma  float64 `yaml:"sigma,omitempty"`  // lognormal shape
+	Scale  int     `yaml:"scale,omitempty"`  // pareto: the smallest size
+	Alpha  float64 `yaml:"alpha,omitempty"`  // pareto shape
+}
+
+// delays returns the distribution as a delay distribution of one nanosecond
+// per byte, so sizes are drawn like delays
+func (cfg *SizeDistributionConfig) delays() *DelayDistributionConfig {
+	return &DelayDistributionConfig{
+		Type:   cfg.Type,
+		Min:    time.Duration(cfg.Min),
+		Max:    time.Duration(cfg.Max),
+		Mean:   time.Duration(cfg.Mean),
+		StdDev: time.Duration(cfg.StdDev),
+		Median: time.Duration(cfg.Median),
+		Sigma:  cfg.Sigma,
+		Scale:  time.Duration(cfg.Scale),
+		Alpha:  cfg.Alpha,
+	}
+}
+
+// EchoConfig turns an endpoint into an echo endpoint, answering with what
+// the backend received
+type EchoConfig struct {
+	MaxBodySize int `yaml:"max_body_size,omitempty"` // Request body bytes included in the response (default: 65536)
+}
+
+// BodyStreamConfig defines how a response body is streamed and cut short.
+// Streamed bodies are sent without Content-Length (chunked on HTTP/1.1) unless
+// content_length is set.
+type BodyStreamConfig struct {
+	BytesPerSecond    int           `yaml:"bytes_per_second,omitempty"`    // Trickle the body at this rate
+	ChunkSize         int           `yaml:"chunk_size,omitempty"`          // Bytes per write (default: bytes_per_second/10, or the whole body)
+	ChunkDelay        time.Duration `yaml:"chunk_delay,omitempty"`         // Pause after each chunk
+	ContentLength     int           `yaml:"content_length,omitempty"`      // Advertised Content-Length; the connection is closed if the body is shorter
+	StallAfterPercent float64       `yaml:"stall_after_percent,omitempty"` // Stop sending after this % of the body and hold the response open
+	StallDuration     time.Duration `yaml:"stall_duration,omitempty"`      // How long to stall before closing (0 = until the client gives up)
+	AbortAfterPercent float64       `yaml:"abort_after_percent,omitempty"` // Close the connection (or reset the stream) after this % of the body
+}
+
+// RequestMatch narrows the requests an endpoint answers beyond path and method.
+// All conditions must hold.
+type RequestMatch struct {
+	Host    string      `yaml:"host,omitempty"`    // Host without port; *.example.com matches subdomains
+	Query   []MatchRule `yaml:"query,omitempty"`   // Query parameter conditions
+	Headers []MatchRule `yaml:"headers,omitempty"` // Request header conditions
+	JSON    []JSONRule  `yaml:"json,omitempty"`    // JSONPath equality checks on the request body
+}
+
+// MatchRule matches a header or query parameter. With only a name, the
+// parameter must be present.
+type MatchRule struct {
+	Name   string `yaml:"name"`
+	Value  string `yaml:"value,omitempty"`  // Exact value (multiple values are joined with ", ")
+	Regex  string `yaml:"regex,omitempty"`  // Value must match
+	Absent bool   `yaml:"absent,omitempty"` // Must not be present
+}
+
+// HTTP2FaultConfig defines the HTTP/2 faults injected on requests to an endpoint
+type HTTP2FaultConfig struct {
+	ResetPercent          float64 `yaml:"reset_percent,omitempty"`           // Percentage of streams reset with RST_STREAM (0-100)
+	ResetCode             string  `yaml:"reset_code,omitempty"`              // RST_STREAM error code for resets and drops (default: INTERNAL_ERROR)
+	GoawayAfterStreams    int     `yaml:"goaway_after_streams,omitempty"`    // Send GOAWAY once a connection has carried this many streams
+	WithholdWindowUpdates bool    `yaml:"withhold_window_updates,omitempty"` // Never send WINDOW_UPDATE for request bodies, stalling uploads
+	IgnorePings           bool    `yaml:"ignore_pings,omitempty"`            // Stop answering PINGs on connections that served this endpoint
 }
 
 // LoggingConfig controls logging behavior
@@ -99,14 +462,87 @@ func validateConfig(config *Config) error {
 			if ep.URL == "" {
 				return fmt.Errorf("endpoint %d: URL is required", i)
 			}
-			if ep.Method == "" {
-				config.Client.Endpoints[i].Method = "GET"
+			if ep.Type == "" {
+				config.Client.Endpoints[i].Type = "http"
+			}
+			switch config.Client.Endpoints[i].Type {
+			case "http":
+				if ep.Method == "" {
+					config.Client.Endpoints[i].Method = "GET"
+				}
+			case "tcp":
+				if err := validateTCPProbe(&config.Client.Endpoints[i]); err != nil {
+					return fmt.Errorf("endpoint %d: %w", i, err)
+				}
+			default:
+				return fmt.Errorf("endpoint %d: type must be 'http' or 'tcp', got: %s", i, ep.Type)
+			}
+			if ep.TLS != nil {
+				if err := validateTLSClientConfig(ep.TLS); err != nil {
+					return fmt.Errorf("endpoint %d: tls: %w", i, err)
+				}
+			}
+			if conn := ep.Connection; conn != nil && (conn.MaxIdleConns < 0 || conn.IdleTimeout < 0 || conn.MaxConnsPerHost < 0) {
+				return fmt.Errorf("endpoint %d: connection: values cannot be negative", i)
+			}
+			if err := validateProtocol(&config.Client.Endpoints[i]); err != nil {
+				return fmt.Errorf("endpoint %d: %w", i, err)
+			}
+			if ep.DNS != nil {
+				if err := validateDNSConfig(ep.DNS); err != nil {
+					return fmt.Errorf("endpoint %d: dns: %w", i, err)
+				}
+			}
+			if ep.Shaping != nil {
+				if err := validateShapingConfig(ep.Shaping); err != nil {
+					return fmt.Errorf("endpoint %d: shaping: %w", i, err)
+				}
+			}
+			if err := validateRequestBody(&config.Client.Endpoints[i]); err != nil {
+				return fmt.Errorf("endpoint %d: %w", i, err)
+			}
+			if ep.Expect != nil {
+				if _, err := compileExpectations(ep.Expect); err != nil {
+					return fmt.Errorf("endpoint %d: expect: %w", i, err)
+				}
 			}
 		}
 		// Set default request timeout if not specified
 		if config.Client.RequestTimeout == 0 {
 			config.Client.RequestTimeout = 30 * time.Second
 		}
+		if config.Client.Report == nil {
+			config.Client.Report = &ReportConfig{}
+		}
+		switch config.Client.Report.Format {
+		case "":
+			config.Client.Report.Format = "table"
+		case "table", "json", "markdown":
+		default:
+			return fmt.Errorf("report: format must be 'table', 'json' or 'markdown', got: %s", config.Client.Report.Format)
+		}
+		if results := config.Client.Results; results != nil {
+			if results.File == "" {
+				return fmt.Errorf("results: file is required")
+			}
+			if results.MaxSizeMB < 0 || results.MaxBackups < 0 {
+				return fmt.Errorf("results: max_size_mb and max_backups cannot be negative")
+			}
+			if results.MaxSizeMB == 0 {
+				results.MaxSizeMB = 100
+			}
+			if results.MaxBackups == 0 {
+				results.MaxBackups = 5
+			}
+		}
+		if len(config.Client.Thresholds) > 0 && config.Client.Timeout <= 0 {
+			return fmt.Errorf("thresholds require a client timeout")
+		}
+		for _, expr := range config.Client.Thresholds {
+			if _, err := parseThreshold(expr); err != nil {
+				return err
+			}
+		}
 		
 		// MaxConcurrentRequests defaults to 0 (unlimited) if not specified
 	}
@@ -119,32 +555,58 @@ func validateConfig(config *Config) error {
 		if config.Backend.Port == 0 {
 			config.Backend.Port = 8080 // Default port
 		}
-		if len(config.Backend.Endpoints) == 0 {
-			return fmt.Errorf("at least one backend endpoint must be defined")
+		if len(config.Backend.Endpoints) == 0 && len(config.Backend.TCPListeners) == 0 && len(config.Backend.DNSListeners) == 0 {
+			return fmt.Errorf("at least one backend endpoint, tcp listener or dns listener must be defined")
 		}
-		for i, ep := range config.Backend.Endpoints {
-			if ep.Path == "" {
-				return fmt.Errorf("backend endpoint %d: path is required", i)
+		for i := range config.Backend.Endpoints {
+			if err := validateBackendEndpoint(&config.Backend.Endpoints[i]); err != nil {
+				return fmt.Errorf("backend endpoint %d: %w", i, err)
+			}
+			for j := range i {
+				if err := endpointsConflict(config.Backend.Endpoints[j], config.Backend.Endpoints[i]); err != nil {
+					return fmt.Errorf("backend endpoint %d: %w", i, err)
+				}
 			}
-			if ep.Method == "" {
-				config.Backend.Endpoints[i].Method = "GET"
+		}
+		if def := config.Backend.Default; def != nil {
+			// The default response answers every method and path
+			def.Path = "/"
+			if def.StatusCode == 0 {
+				def.StatusCode = http.StatusNotFound
 			}
-			if ep.StatusCode == 0 {
-				config.Backend.Endpoints[i].StatusCode = 200
+			if err := validateBackendEndpoint(def); err != nil {
+				return fmt.Errorf("backend default: %w", err)
 			}
-			// Validate percentages
-			if ep.DropPercent < 0 || ep.DropPercent > 100 {
-				return fmt.Errorf("backend endpoint %d: drop_percent must be between 0 and 100", i)
+		}
+		if config.Backend.TLS != nil {
+			if err := validateTLSServerConfig(config.Backend.TLS); err != nil {
+				return fmt.Errorf("backend tls: %w", err)
 			}
-			if ep.IdlePercent < 0 || ep.IdlePercent > 100 {
-				return fmt.Errorf("backend endpoint %d: idle_percent must be between 0 and 100", i)
+		}
+		if config.Backend.HTTP2 != nil {
+			if err := validateHTTP2ServerConfig(config.Backend.HTTP2, config.Backend.TLS != nil); err != nil {
+				return fmt.Errorf("backend http2: %w", err)
 			}
-			if ep.DropPercent + ep.IdlePercent > 100 {
-				return fmt.Errorf("backend endpoint %d: drop_percent + idle_percent cannot exceed 100", i)
+		}
+		if config.Backend.Shaping != nil {
+			if err := validateShapingConfig(config.Backend.Shaping); err != nil {
+				return fmt.Errorf("backend shaping: %w", err)
 			}
-			// Set default idle duration if idle_percent is set
-			if ep.IdlePercent > 0 && ep.IdleDuration == 0 {
-				config.Backend.Endpoints[i].IdleDuration = 30 * time.Second
+		}
+		ports := map[int]bool{config.Backend.Port: true}
+		if config.Backend.Admin != nil {
+			if err := validateAdminConfig(config.Backend.Admin, ports); err != nil {
+				return fmt.Errorf("backend admin: %w", err)
+			}
+		}
+		for i := range config.Backend.TCPListeners {
+			if err := validateTCPListener(&config.Backend.TCPListeners[i], ports); err != nil {
+				return fmt.Errorf("tcp listener %d: %w", i, err)
+			}
+		}
+		for i := range config.Backend.DNSListeners {
+			if err := validateDNSListener(&config.Backend.DNSListeners[i], ports); err != nil {
+				return fmt.Errorf("dns listener %d: %w", i, err)
 			}
 		}
 	}
@@ -156,3 +618,672 @@ func validateConfig(config *Config) error {
 
 	return nil
 }
+
+// validateBackendEndpoint checks a backend endpoint and fills in defaults
+func validateBackendEndpoint(ep *BackendEndpoint) error {
+	if ep.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if err := checkPathPattern(ep.Path); err != nil {
+		return err
+	}
+	if ep.Method == "" && len(ep.Methods) == 0 {
+		ep.Method = "GET"
+		if ep.Echo != nil {
+			ep.Method = "*"
+		}
+	}
+	ep.Method = strings.ToUpper(ep.Method)
+	for i := range ep.Methods {
+		ep.Methods[i] = strings.ToUpper(ep.Methods[i])
+	}
+	if ep.Match != nil {
+		if _, err := compileRequestMatch(ep.Match); err != nil {
+			return fmt.Errorf("match: %w", err)
+		}
+	}
+	if ep.StatusCode == 0 {
+		ep.StatusCode = 200
+	}
+	if ep.Generate != nil {
+		if ep.Body != "" || ep.Template || ep.Echo != nil {
+			return fmt.Errorf("generate cannot be combined with body, template or echo")
+		}
+		if err := validateGeneratedBody(ep.Generate); err != nil {
+			return fmt.Errorf("generate: %w", err)
+		}
+	}
+	if ep.Echo != nil {
+		if ep.Body != "" || ep.Template {
+			return fmt.Errorf("echo cannot be combined with body or template")
+		}
+		if ep.Echo.MaxBodySize < 0 {
+			return fmt.Errorf("echo: max_body_size cannot be negative")
+		}
+		if ep.Echo.MaxBodySize == 0 {
+			ep.Echo.MaxBodySize = 64 * 1024
+		}
+	}
+	if ep.Template {
+		// text/template errors already start with "template:"
+		if _, err := newResponseTemplate(*ep); err != nil {
+			return err
+		}
+	}
+	// Validate percentages
+	if ep.DropPercent < 0 || ep.DropPercent > 100 {
+		return fmt.Errorf("drop_percent must be between 0 and 100")
+	}
+	if ep.IdlePercent < 0 || ep.IdlePercent > 100 {
+		return fmt.Errorf("idle_percent must be between 0 and 100")
+	}
+	if ep.DropPercent+ep.IdlePercent > 100 {
+		return fmt.Errorf("drop_percent + idle_percent cannot exceed 100")
+	}
+	if ep.FaultSchedule != nil {
+		if ep.DropPercent > 0 || ep.IdlePercent > 0 || (ep.HTTP2 != nil && ep.HTTP2.ResetPercent > 0) {
+			return fmt.Errorf("fault_schedule cannot be combined with drop_percent, idle_percent or http2.reset_percent")
+		}
+		if err := validateFaultSchedule(ep.FaultSchedule); err != nil {
+			return fmt.Errorf("fault_schedule: %w", err)
+		}
+	}
+	if ep.Scenario != nil {
+		if err := validateScenario(ep.Scenario); err != nil {
+			return fmt.Errorf("scenario: %w", err)
+		}
+	}
+	if ep.DelayDistribution != nil {
+		if ep.Delay > 0 {
+			return fmt.Errorf("delay and delay_distribution are mutually exclusive")
+		}
+		if err := validateDelayDistribution(ep.DelayDistribution); err != nil {
+			return fmt.Errorf("delay_distribution: %w", err)
+		}
+	}
+	if ep.Stream != nil {
+		bodyLen := len(ep.Body)
+		if ep.Generate != nil || ep.Template || ep.Echo != nil {
+			// Only known per response, where content_length is checked again
+			bodyLen = -1
+		}
+		if err := validateBodyStreamConfig(ep.Stream, bodyLen); err != nil {
+			return fmt.Errorf("stream: %w", err)
+		}
+	}
+	if ep.HTTP2 != nil {
+		if err := validateHTTP2FaultConfig(ep.HTTP2); err != nil {
+			return fmt.Errorf("http2: %w", err)
+		}
+		if ep.DropPercent+ep.IdlePercent+ep.HTTP2.ResetPercent > 100 {
+			return fmt.Errorf("drop_percent + idle_percent + http2.reset_percent cannot exceed 100")
+		}
+	}
+	// Set default idle duration if idle_percent is set
+	if ep.IdlePercent > 0 && ep.IdleDuration == 0 {
+		ep.IdleDuration = 30 * time.Second
+	}
+	return nil
+}
+
+// endpointsConflict reports an error when b reuses the name of a, or has the
+// same route as a and could never be reached
+func endpointsConflict(a, b BackendEndpoint) error {
+	if b.Name != "" && a.Name == b.Name {
+		return fmt.Errorf("name %s is already defined", b.Name)
+	}
+	if a.Path == b.Path && slices.Equal(a.allowedMethods(), b.allowedMethods()) && reflect.DeepEqual(a.Match, b.Match) {
+		return fmt.Errorf("path %s is already defined with the same methods and match", b.Path)
+	}
+	return nil
+}
+
+// allowedMethods returns the sorted methods an endpoint answers
+func (ep BackendEndpoint) allowedMethods() []string {
+	methods := slices.Clone(ep.Methods)
+	if ep.Method != "" {
+		methods = append(methods, ep.Method)
+	}
+	slices.Sort(methods)
+	return slices.Compact(methods)
+}
+
+// streamName identifies an endpoint in the names of its random streams
+func (ep BackendEndpoint) streamName() string {
+	if ep.Name != "" {
+		return ep.Name
+	}
+	return strings.Join(ep.allowedMethods(), ",") + " " + ep.Path
+}
+
+// validateAdminConfig checks the admin API configuration
+func validateAdminConfig(cfg *AdminConfig, ports map[int]bool) error {
+	if cfg.Port == 0 {
+		return fmt.Errorf("port is required")
+	}
+	if ports[cfg.Port] {
+		return fmt.Errorf("port %d is already in use by another listener", cfg.Port)
+	}
+	ports[cfg.Port] = true
+	if cfg.Token == "" && cfg.TokenFile == "" {
+		return fmt.Errorf("token or token_file is required")
+	}
+	return nil
+}
+
+// validateTCPProbe checks a tcp endpoint and fills in defaults
+func validateTCPProbe(ep *EndpointConfig) error {
+	u, err := url.Parse(ep.URL)
+	if err != nil || u.Scheme != "tcp" || u.Port() == "" {
+		return fmt.Errorf("tcp endpoints need a URL of the form tcp://host:port, got: %s", ep.URL)
+	}
+	ep.Method = "TCP"
+	if ep.TCP == nil {
+		ep.TCP = &TCPProbeConfig{}
+	}
+	if ep.TCP.ExpectRegex != "" {
+		if _, err := regexp.Compile(ep.TCP.ExpectRegex); err != nil {
+			return fmt.Errorf("invalid expect_regex: %w", err)
+		}
+	}
+	if ep.TCP.ReadTimeout == 0 {
+		ep.TCP.ReadTimeout = 5 * time.Second
+	}
+	if ep.TCP.MaxReadBytes == 0 {
+		ep.TCP.MaxReadBytes = 64 * 1024
+	}
+	return nil
+}
+
+// validateTCPListener checks a TCP listener definition and fills in defaults
+func validateTCPListener(l *TCPListenerConfig, ports map[int]bool) error {
+	if l.Port == 0 {
+		return fmt.Errorf("port is required")
+	}
+	if ports[l.Port] {
+		return fmt.Errorf("port %d is already in use by another listener", l.Port)
+	}
+	ports[l.Port] = true
+	if l.Name == "" {
+		l.Name = fmt.Sprintf("tcp-%d", l.Port)
+	}
+	if l.Behavior == "" {
+		l.Behavior = "echo"
+	}
+	switch l.Behavior {
+	case "echo", "banner", "close", "no_read", "reset":
+	default:
+		return fmt.Errorf("behavior must be 'echo', 'banner', 'close', 'no_read', or 'reset', got: %s", l.Behavior)
+	}
+	if l.DropPercent < 0 || l.DropPercent > 100 {
+		return fmt.Errorf("drop_percent must be between 0 and 100")
+	}
+	if l.IdlePercent < 0 || l.IdlePercent > 100 {
+		return fmt.Errorf("idle_percent must be between 0 and 100")
+	}
+	if l.DropPercent+l.IdlePercent > 100 {
+		return fmt.Errorf("drop_percent + idle_percent cannot exceed 100")
+	}
+	if l.IdlePercent > 0 && l.IdleDuration == 0 {
+		l.IdleDuration = 30 * time.Second
+	}
+	if l.TLS != nil {
+		if err := validateTLSServerConfig(l.TLS); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+	}
+	if l.Shaping != nil {
+		if err := validateShapingConfig(l.Shaping); err != nil {
+			return fmt.Errorf("shaping: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateShapingConfig checks a shaping configuration and fills in defaults
+func validateShapingConfig(cfg *ShapingConfig) error {
+	if cfg.BytesPerSecond < 0 || cfg.AggregateBytesPerSecond < 0 || cfg.Latency < 0 || cfg.Jitter < 0 || cfg.StallDuration < 0 {
+		return fmt.Errorf("values cannot be negative")
+	}
+	if cfg.StallPercent < 0 || cfg.StallPercent > 100 {
+		return fmt.Errorf("stall_percent must be between 0 and 100")
+	}
+	if cfg.StallPercent > 0 && cfg.StallDuration == 0 {
+		cfg.StallDuration = time.Second
+	}
+	return nil
+}
+
+// validateProtocol checks the protocol of an http endpoint against its URL
+func validateProtocol(ep *EndpointConfig) error {
+	if ep.Type != "http" {
+		return nil
+	}
+	if ep.Protocol == "" {
+		ep.Protocol = "auto"
+	}
+	u, err := url.Parse(ep.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	switch ep.Protocol {
+	case "auto", "http1":
+	case "h2":
+		if u.Scheme != "https" {
+			return fmt.Errorf("protocol h2 needs an https:// URL, use h2c for plain HTTP/2")
+		}
+		if ep.TLS != nil && len(ep.TLS.ALPN) > 0 && !slices.Contains(ep.TLS.ALPN, "h2") {
+			return fmt.Errorf("protocol h2 needs h2 in tls.alpn")
+		}
+	case "h2c":
+		if u.Scheme != "http" {
+			return fmt.Errorf("protocol h2c needs an http:// URL")
+		}
+	default:
+		return fmt.Errorf("protocol must be 'auto', 'http1', 'h2' or 'h2c', got: %s", ep.Protocol)
+	}
+	return nil
+}
+
+// validateHTTP2ServerConfig checks the HTTP/2 settings of the backend listener
+func validateHTTP2ServerConfig(cfg *HTTP2ServerConfig, tls bool) error {
+	if cfg.Disable && cfg.H2C {
+		return fmt.Errorf("h2c cannot be enabled when HTTP/2 is disabled")
+	}
+	if cfg.H2C && tls {
+		return fmt.Errorf("h2c is only available without tls")
+	}
+	if cfg.MaxConcurrentStreams < 0 || cfg.StreamWindowSize < 0 || cfg.ConnWindowSize < 0 || cfg.MaxReadFrameSize < 0 ||
+		cfg.IdleTimeout < 0 || cfg.MaxConnectionAge < 0 {
+		return fmt.Errorf("values cannot be negative")
+	}
+	if cfg.MaxReadFrameSize != 0 && (cfg.MaxReadFrameSize < 16*1024 || cfg.MaxReadFrameSize > 16*1024*1024-1) {
+		return fmt.Errorf("max_read_frame_size must be between 16384 and 16777215")
+	}
+	return nil
+}
+
+// validateFaultSchedule checks a fault schedule and compiles its outcomes
+func validateFaultSchedule(cfg *FaultScheduleConfig) error {
+	if (cfg.Pattern == "") == (cfg.Every == 0) {
+		return fmt.Errorf("set either pattern, or every and fault")
+	}
+	if cfg.Pattern != "" {
+		outcomes, err := parseFaultPattern(cfg.Pattern)
+		if err != nil {
+			return err
+		}
+		cfg.outcomes = outcomes
+		return nil
+	}
+	if cfg.Every < 1 {
+		return fmt.Errorf("every must be at least 1")
+	}
+	switch cfg.Fault {
+	case outcomeDrop, outcomeIdle, outcomeReset:
+	default:
+		return fmt.Errorf("fault must be 'drop', 'idle' or 'reset', got: %s", cfg.Fault)
+	}
+	cfg.outcomes = make([]string, cfg.Every)
+	for i := range cfg.outcomes {
+		cfg.outcomes[i] = outcomeOK
+	}
+	cfg.outcomes[cfg.Every-1] = cfg.Fault
+	return nil
+}
+
+// validateScenario checks a scenario and compiles its outcomes
+func validateScenario(cfg *ScenarioConfig) error {
+	if cfg.Requests < 0 || cfg.Duration < 0 || cfg.Healthy < 0 || cfg.Unhealthy < 0 || cfg.RetryAfter < 0 {
+		return fmt.Errorf("values cannot be negative")
+	}
+	if cfg.FailWith == "" {
+		cfg.FailWith = "503"
+	}
+	failure, err := parseScenarioStep(cfg.FailWith)
+	if err != nil {
+		return fmt.Errorf("fail_with: %w", err)
+	}
+	if failure.ok() {
+		return fmt.Errorf("fail_with must be a failure")
+	}
+	cfg.failure = failure
+
+	switch cfg.Type {
+	case scenarioFailFirst, scenarioCircuit:
+		if cfg.Requests == 0 {
+			return fmt.Errorf("%s requires requests", cfg.Type)
+		}
+	case scenarioWarmup:
+		if cfg.Duration == 0 {
+			return fmt.Errorf("warmup requires duration")
+		}
+	case scenarioFlap:
+		if cfg.Healthy == 0 || cfg.Unhealthy == 0 {
+			return fmt.Errorf("flap requires healthy and unhealthy")
+		}
+	case scenarioSequence:
+		if len(cfg.Sequence) == 0 {
+			return fmt.Errorf("sequence requires at least one entry in sequence")
+		}
+		cfg.steps = make([]scenarioStep, len(cfg.Sequence))
+		for i, text := range cfg.Sequence {
+			if cfg.steps[i], err = parseScenarioStep(text); err != nil {
+				return fmt.Errorf("sequence[%d]: %w", i, err)
+			}
+		}
+	default:
+		return fmt.Errorf("type must be 'fail_first', 'warmup', 'flap', 'sequence' or 'circuit', got: %s", cfg.Type)
+	}
+
+	switch {
+	case cfg.Key == "":
+	case cfg.Type == scenarioWarmup || cfg.Type == scenarioFlap:
+		return fmt.Errorf("key is not supported by %s, which follows the clock", cfg.Type)
+	case cfg.Key == "client_ip":
+	case strings.HasPrefix(cfg.Key, "header:") && len(cfg.Key) > len("header:"):
+	default:
+		return fmt.Errorf("key must be 'client_ip' or 'header:<name>', got: %s", cfg.Key)
+	}
+	return nil
+}
+
+// validateDelayDistribution checks the parameters of a delay distribution and
+// loads the latencies of an empirical one
+func validateDelayDistribution(cfg *DelayDistributionConfig) error {
+	if cfg.Min < 0 || cfg.Max < 0 || cfg.Mean < 0 || cfg.StdDev < 0 || cfg.Median < 0 || cfg.Scale < 0 || cfg.Sigma < 0 || cfg.Alpha < 0 {
+		return fmt.Errorf("values cannot be negative")
+	}
+	if cfg.Max > 0 && cfg.Max < cfg.Min {
+		return fmt.Errorf("max cannot be smaller than min")
+	}
+	switch cfg.Type {
+	case "uniform":
+		if cfg.Max == 0 {
+			return fmt.Errorf("uniform requires max")
+		}
+	case "normal":
+		if cfg.Mean == 0 {
+			return fmt.Errorf("normal requires mean")
+		}
+	case "lognormal":
+		if cfg.Median == 0 || cfg.Sigma == 0 {
+			return fmt.Errorf("lognormal requires median and sigma")
+		}
+	case "exponential":
+		if cfg.Mean == 0 {
+			return fmt.Errorf("exponential requires mean")
+		}
+	case "pareto":
+		if cfg.Scale == 0 || cfg.Alpha == 0 {
+			return fmt.Errorf("pareto requires scale and alpha")
+		}
+	case "empirical":
+		if cfg.File == "" {
+			return fmt.Errorf("empirical requires file")
+		}
+		samples, err := loadLatencies(cfg.File)
+		if err != nil {
+			return err
+		}
+		cfg.samples = samples
+	default:
+		return fmt.Errorf("type must be 'uniform', 'normal', 'lognormal', 'exponential', 'pareto' or 'empirical', got: %s", cfg.Type)
+	}
+	return nil
+}
+
+// validateGeneratedBody checks a generated body and fills in defaults
+func validateGeneratedBody(cfg *GeneratedBodyConfig) error {
+	if cfg.Size < 0 || cfg.Records < 0 || cfg.RecordSize < 0 {
+		return fmt.Errorf("values cannot be negative")
+	}
+	if cfg.Content == "" {
+		cfg.Content = "pattern"
+	}
+	if cfg.RecordSize == 0 {
+		cfg.RecordSize = defaultRecordSize
+	}
+	switch cfg.Content {
+	case "pattern":
+		if cfg.Pattern == "" {
+			cfg.Pattern = defaultPattern
+		}
+	case "random":
+	case "json", "ndjson":
+		if cfg.Records > 0 && (cfg.Size > 0 || cfg.SizeDistribution != nil) {
+			return fmt.Errorf("records cannot be combined with size or size_distribution")
+		}
+		if cfg.Records > 0 {
+			return nil
+		}
+	default:
+		return fmt.Errorf("content must be 'pattern', 'random', 'json' or 'ndjson', got: %s", cfg.Content)
+	}
+
+	if cfg.SizeDistribution != nil {
+		if cfg.Size > 0 {
+			return fmt.Errorf("size and size_distribution are mutually exclusive")
+		}
+		if cfg.SizeDistribution.Type == "empirical" {
+			return fmt.Errorf("size_distribution: type must be 'uniform', 'normal', 'lognormal', 'exponential' or 'pareto'")
+		}
+		if err := validateDelayDistribution(cfg.SizeDistribution.delays()); err != nil {
+			return fmt.Errorf("size_distribution: %w", err)
+		}
+		return nil
+	}
+	if cfg.Size == 0 {
+		return fmt.Errorf("size or size_distribution is required")
+	}
+	return nil
+}
+
+// validateRequestBody checks the body settings of a client endpoint and
+// parses its templates and data file
+func validateRequestBody(ep *EndpointConfig) error {
+	sources := 0
+	for _, set := range []bool{ep.Body != "", ep.BodyFile != "", ep.Generate != nil, ep.Multipart != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("body, body_file, generate and multipart are mutually exclusive")
+	}
+	if ep.Type == "tcp" && (ep.BodyFile != "" || ep.Generate != nil || ep.Multipart != nil || ep.Template || ep.DataFile != "") {
+		return fmt.Errorf("body_file, generate, multipart, template and data_file are not supported by tcp endpoints")
+	}
+	if ep.Generate != nil {
+		if err := validateGeneratedBody(ep.Generate); err != nil {
+			return fmt.Errorf("generate: %w", err)
+		}
+	}
+	if mp := ep.Multipart; mp != nil {
+		if len(mp.Fields) == 0 && len(mp.Files) == 0 {
+			return fmt.Errorf("multipart: fields or files are required")
+		}
+		for j := range mp.Files {
+			file := &mp.Files[j]
+			if file.Field == "" || file.File == "" {
+				return fmt.Errorf("multipart: files[%d]: field and file are required", j)
+			}
+			if file.Filename == "" {
+				file.Filename = filepath.Base(file.File)
+			}
+			if file.ContentType == "" {
+				file.ContentType = "application/octet-stream"
+			}
+		}
+	}
+	if ep.BodyFile != "" && !ep.Template {
+		if _, err := os.Stat(ep.BodyFile); err != nil {
+			return fmt.Errorf("body_file: %w", err)
+		}
+	}
+	if ep.DataFile != "" && !ep.Template {
+		return fmt.Errorf("data_file requires template")
+	}
+	switch ep.DataOrder {
+	case "":
+		ep.DataOrder = "sequential"
+	case "sequential", "random":
+	default:
+		return fmt.Errorf("data_order must be 'sequential' or 'random', got: %s", ep.DataOrder)
+	}
+	// text/template errors already start with "template:"
+	_, err := newRequestBuilder(*ep)
+	return err
+}
+
+// validateBodyStreamConfig checks the body streaming settings of an endpoint
+// whose body is bodyLen bytes long, or -1 when it changes between responses
+func validateBodyStreamConfig(cfg *BodyStreamConfig, bodyLen int) error {
+	if cfg.BytesPerSecond < 0 || cfg.ChunkSize < 0 || cfg.ChunkDelay < 0 || cfg.ContentLength < 0 || cfg.StallDuration < 0 {
+		return fmt.Errorf("values cannot be negative")
+	}
+	if cfg.ContentLength > 0 && cfg.ContentLength < bodyLen {
+		return fmt.Errorf("content_length (%d) cannot be smaller than the body (%d bytes)", cfg.ContentLength, bodyLen)
+	}
+	if cfg.StallAfterPercent < 0 || cfg.StallAfterPercent > 100 {
+		return fmt.Errorf("stall_after_percent must be between 0 and 100")
+	}
+	if cfg.AbortAfterPercent < 0 || cfg.AbortAfterPercent > 100 {
+		return fmt.Errorf("abort_after_percent must be between 0 and 100")
+	}
+	if cfg.StallAfterPercent > 0 && cfg.AbortAfterPercent > 0 {
+		return fmt.Errorf("stall_after_percent and abort_after_percent are mutually exclusive")
+	}
+	return nil
+}
+
+// validateHTTP2FaultConfig checks the HTTP/2 faults of an endpoint and fills in defaults
+func validateHTTP2FaultConfig(cfg *HTTP2FaultConfig) error {
+	if cfg.ResetPercent < 0 || cfg.ResetPercent > 100 {
+		return fmt.Errorf("reset_percent must be between 0 and 100")
+	}
+	if cfg.ResetCode == "" {
+		cfg.ResetCode = "INTERNAL_ERROR"
+	}
+	if _, err := parseHTTP2ErrCode(cfg.ResetCode); err != nil {
+		return err
+	}
+	if cfg.GoawayAfterStreams < 0 {
+		return fmt.Errorf("goaway_after_streams cannot be negative")
+	}
+	return nil
+}
+
+// validateDNSConfig checks an endpoint DNS configuration and fills in defaults
+func validateDNSConfig(cfg *DNSConfig) error {
+	switch cfg.IPFamily {
+	case "":
+		cfg.IPFamily = "dual"
+	case "dual", "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("ip_family must be 'dual', 'ipv4' or 'ipv6', got: %s", cfg.IPFamily)
+	}
+	if _, err := parseResolveOverrides(cfg.Resolve, cfg.IPFamily); err != nil {
+		return err
+	}
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return nil
+}
+
+// validateDNSListener checks a DNS listener definition and fills in defaults
+func validateDNSListener(l *DNSListenerConfig, ports map[int]bool) error {
+	if l.Port == 0 {
+		return fmt.Errorf("port is required")
+	}
+	if ports[l.Port] {
+		return fmt.Errorf("port %d is already in use by another listener", l.Port)
+	}
+	ports[l.Port] = true
+	if l.Name == "" {
+		l.Name = fmt.Sprintf("dns-%d", l.Port)
+	}
+	for _, p := range []float64{l.DropPercent, l.ServfailPercent, l.NXDomainPercent, l.TruncatePercent} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("drop_percent, servfail_percent, nxdomain_percent and truncate_percent must be between 0 and 100")
+		}
+	}
+	if l.DropPercent+l.ServfailPercent+l.NXDomainPercent+l.TruncatePercent > 100 {
+		return fmt.Errorf("drop_percent + servfail_percent + nxdomain_percent + truncate_percent cannot exceed 100")
+	}
+	for i := range l.Records {
+		if l.Records[i].Type == "" {
+			l.Records[i].Type = "A"
+		}
+		if l.Records[i].TTL == 0 {
+			l.Records[i].TTL = 30 * time.Second
+		}
+	}
+	if _, err := buildZone(l.Records); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateTLSClientConfig checks an endpoint TLS configuration
+func validateTLSClientConfig(cfg *TLSClientConfig) error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if _, err := parseTLSVersion(cfg.MinVersion); err != nil {
+		return fmt.Errorf("min_version: %w", err)
+	}
+	if _, err := parseTLSVersion(cfg.MaxVersion); err != nil {
+		return fmt.Errorf("max_version: %w", err)
+	}
+	return nil
+}
+
+// validateTLSServerConfig checks a listener TLS configuration and fills in defaults
+func validateTLSServerConfig(cfg *TLSServerConfig) error {
+	if cfg.AutoGenerate == nil && cfg.CertFile == "" && len(cfg.SNI) == 0 {
+		return fmt.Errorf("cert_file/key_file, auto_generate or sni certificates are required")
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if cfg.AutoGenerate != nil {
+		if cfg.AutoGenerate.Dir == "" {
+			return fmt.Errorf("auto_generate.dir is required")
+		}
+		if cfg.AutoGenerate.ValidFor == 0 {
+			cfg.AutoGenerate.ValidFor = 365 * 24 * time.Hour
+		}
+	}
+	if cfg.ClientAuth == "" {
+		cfg.ClientAuth = "none"
+	}
+	if cfg.ClientAuth != "none" && cfg.ClientAuth != "request" && cfg.ClientAuth != "require" {
+		return fmt.Errorf("client_auth must be 'none', 'request', or 'require', got: %s", cfg.ClientAuth)
+	}
+	if _, err := parseTLSVersion(cfg.MinVersion); err != nil {
+		return fmt.Errorf("min_version: %w", err)
+	}
+	if _, err := parseTLSVersion(cfg.MaxVersion); err != nil {
+		return fmt.Errorf("max_version: %w", err)
+	}
+	if _, err := parseCipherSuites(cfg.CipherSuites); err != nil {
+		return err
+	}
+	for i, sni := range cfg.SNI {
+		if len(sni.ServerNames) == 0 {
+			return fmt.Errorf("sni entry %d: server_names is required", i)
+		}
+		if (sni.CertFile == "") != (sni.KeyFile == "") {
+			return fmt.Errorf("sni entry %d: cert_file and key_file must be set together", i)
+		}
+		if sni.CertFile == "" && cfg.AutoGenerate == nil {
+			return fmt.Errorf("sni entry %d: cert_file/key_file are required unless auto_generate is set", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// delaySampler draws response delays from a DelayDistributionConfig
+type delaySampler struct {
+	config *DelayDistributionConfig
+	mu     sync.Mutex // rand.Rand is not safe for concurrent use
+	rng    *rand.Rand
+}
+
+// newDelaySampler creates a sampler for a validated distribution. Without a
+// seed of its own it draws from the stream of the run seed.
+func newDelaySampler(cfg *DelayDistributionConfig, stream string) *delaySampler {
+	rng := newRand(stream)
+	if cfg.Seed != 0 {
+		rng = rand.New(rand.NewPCG(cfg.Seed, cfg.Seed))
+	}
+	return &delaySampler{config: cfg, rng: rng}
+}
+
+// sample returns the next delay, clamped to the configured min and max
+func (s *delaySampler) sample() time.Duration {
+	cfg := s.config
+	s.mu.Lock()
+	var d float64 // nanoseconds
+	switch cfg.Type {
+	case "uniform":
+		d = float64(cfg.Min) + s.rng.Float64()*float64(cfg.Max-cfg.Min)
+	case "normal":
+		d = float64(cfg.Mean) + s.rng.NormFloat64()*float64(cfg.StdDev)
+	case "lognormal":
+		d = float64(cfg.Median) * math.Exp(cfg.Sigma*s.rng.NormFloat64())
+	case "exponential":
+		d = s.rng.ExpFloat64() * float64(cfg.Mean)
+	case "pareto":
+		// Inverse transform with u in (0, 1]
+		d = float64(cfg.Scale) / math.Pow(1-s.rng.Float64(), 1/cfg.Alpha)
+	case "empirical":
+		d = interpolate(cfg.samples, s.rng.Float64())
+	}
+	s.mu.Unlock()
+
+	d = math.Max(d, float64(cfg.Min))
+	if cfg.Max > 0 {
+		d = math.Min(d, float64(cfg.Max))
+	}
+	return time.Duration(d)
+}
+
+// interpolate returns the q quantile of sorted latencies, interpolating
+// linearly between observations
+func interpolate(sorted []time.Duration, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i >= len(sorted)-1 {
+		return float64(sorted[len(sorted)-1])
+	}
+	frac := pos - float64(i)
+	return float64(sorted[i]) + frac*float64(sorted[i+1]-sorted[i])
+}
+
+// loadLatencies reads observed latencies from the first column of a CSV file.
+// Values are milliseconds ("12.5") or durations ("12.5ms"); a header line is skipped.
+func loadLatencies(filename string) ([]time.Duration, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	var latencies []time.Duration
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		value := strings.TrimSpace(record[0])
+		if value == "" {
+			continue
+		}
+		latency, err := parseLatency(value)
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s line %d: invalid latency %q", filename, line, value)
+		}
+		if latency < 0 {
+			return nil, fmt.Errorf("%s line %d: latency cannot be negative", filename, line)
+		}
+		latencies = append(latencies, latency)
+	}
+	if len(latencies) == 0 {
+		return nil, fmt.Errorf("%s: no latencies found", filename)
+	}
+	slices.Sort(latencies)
+	return latencies, nil
+}
+
+// parseLatency parses milliseconds or a Go duration
+func parseLatency(value string) (time.Duration, error) {
+	if ms, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(ms * float64(time.Millisecond)), nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// endpointDialer opens the connections of one endpoint using its DNS settings
+type endpointDialer struct {
+	dialer    *net.Dialer
+	network   string              // tcp, tcp4 or tcp6
+	overrides map[string][]string // host:port -> addresses, like curl --resolve
+	shaper    *shaper             // Network shaping of the connections, may be nil
+}
+
+// newEndpointDialer creates a dialer with the resolver, address family and
+// overrides of cfg, which may be nil
+func newEndpointDialer(cfg *DNSConfig, timeout time.Duration) (*endpointDialer, error) {
+	d := &endpointDialer{
+		dialer:  &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second},
+		network: "tcp",
+	}
+	if cfg == nil {
+		return d, nil
+	}
+
+	overrides, err := parseResolveOverrides(cfg.Resolve, cfg.IPFamily)
+	if err != nil {
+		return nil, err
+	}
+	d.overrides = overrides
+
+	switch cfg.IPFamily {
+	case "ipv4":
+		d.network = "tcp4"
+	case "ipv6":
+		d.network = "tcp6"
+	}
+
+	if cfg.Server != "" {
+		server := cfg.Server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		queryDialer := &net.Dialer{Timeout: cfg.Timeout}
+		d.dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return queryDialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return d, nil
+}
+
+// DialContext connects to addr, trying the override addresses in order when
+// addr has one
+func (d *endpointDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network == "tcp" {
+		network = d.network
+	}
+	addrs, ok := d.overrides[strings.ToLower(addr)]
+	if !ok {
+		conn, err := d.dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return d.shaper.wrap(conn), nil
+	}
+
+	_, port, _ := net.SplitHostPort(addr)
+	var err error
+	for _, ip := range addrs {
+		var conn net.Conn
+		if conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+			return d.shaper.wrap(conn), nil
+		}
+	}
+	return nil, err
+}
+
+// parseResolveOverrides parses curl-style "host:port:addr[,addr...]" entries
+func parseResolveOverrides(entries []string, family string) (map[string][]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	overrides := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("resolve entry %q must have the form host:port:addr[,addr...]", entry)
+		}
+		var addrs []string
+		for _, addr := range strings.Split(parts[2], ",") {
+			addr = strings.Trim(strings.TrimSpace(addr), "[]")
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("resolve entry %q: invalid IP address %q", entry, addr)
+			}
+			if (family == "ipv4" && ip.To4() == nil) || (family == "ipv6" && ip.To4() != nil) {
+				return nil, fmt.Errorf("resolve entry %q: %s does not match ip_family %s", entry, addr, family)
+			}
+			addrs = append(addrs, ip.String())
+		}
+		overrides[strings.ToLower(net.JoinHostPort(parts[0], parts[1]))] = addrs
+	}
+	return overrides, nil
+}
+
+// classifyDNSError maps a lookup error to an error_type label
+func classifyDNSError(err error) string {
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return "dns_failed"
+	}
+	switch {
+	case dnsErr.IsNotFound:
+		return "dns_nxdomain"
+	case dnsErr.IsTimeout:
+		return "dns_timeout"
+	case strings.Contains(dnsErr.Err, "server misbehaving"):
+		// The Go resolver reports SERVFAIL (and other server error codes) this way
+		return "dns_servfail"
+	}
+	return "dns_failed"
+}
+
+// recordDNS exports the outcome of a lookup made for an endpoint
+func (c *Client) recordDNS(endpoint EndpointConfig, info httptrace.DNSDoneInfo) {
+	if info.Err != nil {
+		c.metrics.ClientDNSLookups.WithLabelValues(endpoint.Name, classifyDNSError(info.Err)).Inc()
+		return
+	}
+	c.metrics.ClientDNSLookups.WithLabelValues(endpoint.Name, "success").Inc()
+	c.metrics.ClientDNSAddresses.WithLabelValues(endpoint.Name).Set(float64(len(info.Addrs)))
+}
+
+// recordConnectedAddress exports the address a new connection was opened to
+func (c *Client) recordConnectedAddress(endpoint EndpointConfig, addr string) {
+	c.metrics.ClientConnectedAddress.DeletePartialMatch(prometheus.Labels{"endpoint": endpoint.Name})
+	c.metrics.ClientConnectedAddress.WithLabelValues(endpoint.Name, addr).Set(1)
+}
+
+// ipStrings converts lookup results for logs and the result stream
+func ipStrings(addrs []net.IPAddr) []string {
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.String())
+	}
+	return ips
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsUDPSize is the largest UDP answer sent to clients that do not announce a size with EDNS0
+const dnsUDPSize = 512
+
+// dnsTCPIdleTimeout closes TCP connections that send no further queries
+const dnsTCPIdleTimeout = 10 * time.Second
+
+// DNSListener represents a DNS server answering from a static zone, with fault injection
+type DNSListener struct {
+	config  DNSListenerConfig
+	logger  *Logger
+	metrics *Metrics
+	zone    map[string][]zoneRecord // Records by lower case FQDN, wildcards as *.example.com.
+	queries atomic.Uint64           // Rotates the order of multi-value answers
+	rng     *lockedRand             // Fault decisions
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// zoneRecord is a DNSRecord converted to wire format resources
+type zoneRecord struct {
+	rtype  dnsmessage.Type
+	ttl    uint32
+	bodies []dnsmessage.ResourceBody
+}
+
+// NewDNSListener creates a DNS listener from a validated configuration
+func NewDNSListener(config DNSListenerConfig, logger *Logger, metrics *Metrics) (*DNSListener, error) {
+	zone, err := buildZone(config.Records)
+	if err != nil {
+		return nil, fmt.Errorf("dns listener %s: %w", config.Name, err)
+	}
+	return &DNSListener{
+		config:  config,
+		logger:  logger,
+		metrics: metrics,
+		zone:    zone,
+		rng:     newLockedRand("dns " + config.Name),
+		conns:   make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// buildZone converts the configured records into wire format resources
+func buildZone(records []DNSRecord) (map[string][]zoneRecord, error) {
+	zone := make(map[string][]zoneRecord)
+	for i, record := range records {
+		name := canonicalDNSName(record.Name)
+		if _, err := dnsmessage.NewName(name); err != nil {
+			return nil, fmt.Errorf("record %d: invalid name %q: %w", i, record.Name, err)
+		}
+		if len(record.Values) == 0 {
+			return nil, fmt.Errorf("record %d: values are required", i)
+		}
+
+		zr := zoneRecord{ttl: uint32(record.TTL / time.Second)}
+		switch strings.ToUpper(record.Type) {
+		case "A":
+			zr.rtype = dnsmessage.TypeA
+		case "AAAA":
+			zr.rtype = dnsmessage.TypeAAAA
+		case "CNAME":
+			zr.rtype = dnsmessage.TypeCNAME
+			if len(record.Values) > 1 {
+				return nil, fmt.Errorf("record %d: a CNAME record has a single value", i)
+			}
+		case "TXT":
+			zr.rtype = dnsmessage.TypeTXT
+		default:
+			return nil, fmt.Errorf("record %d: type must be 'A', 'AAAA', 'CNAME' or 'TXT', got: %s", i, record.Type)
+		}
+
+		for _, value := range record.Values {
+			var body dnsmessage.ResourceBody
+			switch zr.rtype {
+			case dnsmessage.TypeA:
+				ip := net.ParseIP(value).To4()
+				if ip == nil {
+					return nil, fmt.Errorf("record %d: %q is not an IPv4 address", i, value)
+				}
+				body = &dnsmessage.AResource{A: [4]byte(ip)}
+			case dnsmessage.TypeAAAA:
+				ip := net.ParseIP(value)
+				if ip == nil || ip.To4() != nil {
+					return nil, fmt.Errorf("record %d: %q is not an IPv6 address", i, value)
+				}
+				body = &dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())}
+			case dnsmessage.TypeCNAME:
+				target, err := dnsmessage.NewName(canonicalDNSName(value))
+				if err != nil {
+					return nil, fmt.Errorf("record %d: invalid CNAME target %q: %w", i, value, err)
+				}
+				body = &dnsmessage.CNAMEResource{CNAME: target}
+			case dnsmessage.TypeTXT:
+				body = &dnsmessage.TXTResource{TXT: []string{value}}
+			}
+			zr.bodies = append(zr.bodies, body)
+		}
+		zone[name] = append(zone[name], zr)
+	}
+	return zone, nil
+}
+
+// canonicalDNSName lower cases a name and makes it fully qualified
+func canonicalDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+// Run serves UDP and TCP queries until the context is cancelled
+func (d *DNSListener) Run(ctx context.Context) error {
+	addr := fmt.Sprintf(":%d", d.config.Port)
+	packetConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("dns listener %s: failed to listen on udp port %d: %w", d.config.Name, d.config.Port, err)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		packetConn.Close()
+		return fmt.Errorf("dns listener %s: failed to listen on tcp port %d: %w", d.config.Name, d.config.Port, err)
+	}
+
+	d.logger.Info("Starting DNS listener [%s] on port %d (udp and tcp, %d names)", d.config.Name, d.config.Port, len(d.zone))
+
+	go func() {
+		<-ctx.Done()
+		packetConn.Close()
+		listener.Close()
+		d.closeAll()
+	}()
+
+	errChan := make(chan error, 2)
+	go func() { errChan <- d.serveTCP(ctx, listener) }()
+	go func() { errChan <- d.serveUDP(ctx, packetConn) }()
+
+	err = <-errChan
+	if ctx.Err() == nil {
+		packetConn.Close()
+		listener.Close()
+		d.closeAll()
+	}
+	<-errChan
+	d.wg.Wait()
+	return err
+}
+
+// serveUDP answers each datagram in its own goroutine so delays do not block other queries
+func (d *DNSListener) serveUDP(ctx context.Context, conn net.PacketConn) error {
+	for {
+		buf := make([]byte, 65535)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return ctx.Err()
+			}
+			return fmt.Errorf("dns listener %s: udp read failed: %w", d.config.Name, err)
+		}
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			if response := d.handle(ctx, buf[:n], "udp", addr); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}()
+	}
+}
+
+// serveTCP accepts connections carrying length-prefixed queries
+func (d *DNSListener) serveTCP(ctx context.Context, listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("dns listener %s: accept failed: %w", d.config.Name, err)
+		}
+
+		d.track(conn)
+		d.wg.Add(1)
+		go d.handleTCP(ctx, conn)
+	}
+}
+
+// handleTCP answers queries on a connection until the client closes it or goes idle
+func (d *DNSListener) handleTCP(ctx context.Context, conn net.Conn) {
+	defer d.wg.Done()
+	defer d.untrack(conn)
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	var pending sync.WaitGroup
+	defer pending.Wait()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(dnsTCPIdleTimeout))
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		query := make([]byte, length)
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+
+		// Queries are answered concurrently, as RFC 7766 allows, so one delayed
+		// answer does not hold back the others on the connection
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			response := d.handle(ctx, query, "tcp", conn.RemoteAddr())
+			if response == nil {
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+		}()
+	}
+}
+
+// handle applies the fault percentages to a query and returns the packed
+// response, or nil when the query is dropped
+func (d *DNSListener) handle(ctx context.Context, packet []byte, protocol string, from net.Addr) []byte {
+	var query dnsmessage.Message
+	if err := query.Unpack(packet); err != nil || query.Header.Response {
+		d.logger.Debug("DNS [%s] ignoring malformed %s packet from %s: %v", d.config.Name, protocol, from, err)
+		d.metrics.DNSBackendQueriesTotal.WithLabelValues(d.config.Name, protocol, "", "malformed").Inc()
+		return nil
+	}
+
+	question := dnsmessage.Question{}
+	if len(query.Questions) > 0 {
+		question = query.Questions[0]
+	}
+	qtype := strings.TrimPrefix(question.Type.String(), "Type")
+
+	fault := ""
+	random := d.rng.Percent()
+	switch {
+	case random < d.config.DropPercent:
+		fault = "drop"
+	case random < d.config.DropPercent+d.config.ServfailPercent:
+		fault = "servfail"
+	case random < d.config.DropPercent+d.config.ServfailPercent+d.config.NXDomainPercent:
+		fault = "nxdomain"
+	case protocol == "udp" && random < d.config.DropPercent+d.config.ServfailPercent+d.config.NXDomainPercent+d.config.TruncatePercent:
+		fault = "truncate"
+	}
+	if fault != "" {
+		d.metrics.DNSBackendFaultsTotal.WithLabelValues(d.config.Name, fault).Inc()
+	}
+
+	if d.config.Delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(d.config.Delay):
+		}
+	}
+
+	if fault == "drop" {
+		d.logger.Warn("Dropping DNS query on [%s] for %s %s from %s (%.1f%% drop rate)",
+			d.config.Name, qtype, question.Name, from, d.config.DropPercent)
+		d.metrics.DNSBackendQueriesTotal.WithLabelValues(d.config.Name, protocol, qtype, "dropped").Inc()
+		return nil
+	}
+
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.Header.ID,
+			Response:           true,
+			OpCode:             query.Header.OpCode,
+			Authoritative:      true,
+			RecursionDesired:   query.Header.RecursionDesired,
+			RecursionAvailable: false,
+		},
+		Questions: query.Questions,
+	}
+	switch {
+	case query.Header.OpCode != 0:
+		response.Header.RCode = dnsmessage.RCodeNotImplemented
+	case len(query.Questions) != 1:
+		response.Header.RCode = dnsmessage.RCodeFormatError
+	case fault == "servfail":
+		response.Header.RCode = dnsmessage.RCodeServerFailure
+	case fault == "nxdomain":
+		response.Header.RCode = dnsmessage.RCodeNameError
+	default:
+		response.Answers, response.Header.RCode = d.resolve(question)
+	}
+
+	// Answer with EDNS0 when the client used it, which also raises the UDP size limit
+	maxSize := dnsUDPSize
+	for _, additional := range query.Additionals {
+		if additional.Header.Type == dnsmessage.TypeOPT {
+			if size := int(additional.Header.Class); size > maxSize {
+				maxSize = size
+			}
+			var opt dnsmessage.ResourceHeader
+			opt.SetEDNS0(maxSize, dnsmessage.RCodeSuccess, false)
+			response.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+			break
+		}
+	}
+
+	packed, err := response.Pack()
+	if err == nil && protocol == "udp" && (fault == "truncate" || len(packed) > maxSize) {
+		// An empty truncated answer makes the client retry over TCP
+		response.Header.Truncated = true
+		response.Answers = nil
+		packed, err = response.Pack()
+	}
+	if err != nil {
+		d.logger.Error("DNS [%s] failed to pack response for %s %s: %v", d.config.Name, qtype, question.Name, err)
+		return nil
+	}
+
+	rcode := dnsRCodeName(response.Header.RCode)
+	if response.Header.Truncated {
+		rcode += "_truncated"
+	}
+	d.metrics.DNSBackendQueriesTotal.WithLabelValues(d.config.Name, protocol, qtype, rcode).Inc()
+	d.logger.Debug("DNS [%s] %s %s %s from %s -> %s (%d answers)",
+		d.config.Name, protocol, qtype, question.Name, from, rcode, len(response.Answers))
+	return packed
+}
+
+// resolve looks up a question in the zone, following CNAME records within it
+func (d *DNSListener) resolve(question dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode) {
+	var answers []dnsmessage.Resource
+	name := question.Name
+	rotation := int(d.queries.Add(1))
+
+	for range 8 {
+		records, ok := d.lookup(name.String())
+		if !ok {
+			if len(answers) > 0 {
+				// The CNAME target is outside the zone; the client resolves it itself
+				return answers, dnsmessage.RCodeSuccess
+			}
+			return nil, dnsmessage.RCodeNameError
+		}
+
+		var cname *dnsmessage.CNAMEResource
+		var cnameTTL uint32
+		for _, record := range records {
+			if record.rtype == dnsmessage.TypeCNAME && question.Type != dnsmessage.TypeCNAME {
+				// Answered below, before following it
+				cname, cnameTTL = record.bodies[0].(*dnsmessage.CNAMEResource), record.ttl
+				continue
+			}
+			if record.rtype != question.Type && question.Type != dnsmessage.TypeALL {
+				continue
+			}
+			for i := range record.bodies {
+				body := record.bodies[i]
+				if d.config.Rotate {
+					body = record.bodies[(i+rotation)%len(record.bodies)]
+				}
+				answers = append(answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: name, Type: record.rtype, Class: dnsmessage.ClassINET, TTL: record.ttl},
+					Body:   body,
+				})
+			}
+		}
+		if cname == nil {
+			return answers, dnsmessage.RCodeSuccess
+		}
+		answers = append(answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: cnameTTL},
+			Body:   cname,
+		})
+		name = cname.CNAME
+	}
+	return answers, dnsmessage.RCodeServerFailure
+}
+
+// lookup returns the records of a name, falling back to the closest wildcard
+func (d *DNSListener) lookup(name string) ([]zoneRecord, bool) {
+	name = strings.ToLower(name)
+	if records, ok := d.zone[name]; ok {
+		return records, true
+	}
+	for labels := name; ; {
+		_, parent, found := strings.Cut(labels, ".")
+		if !found || parent == "" {
+			return nil, false
+		}
+		if records, ok := d.zone["*."+parent]; ok {
+			return records, true
+		}
+		labels = parent
+	}
+}
+
+// dnsRCodeName returns the conventional name of a response code for metric labels
+func dnsRCodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return rcode.String()
+}
+
+// track registers an open TCP connection so it can be closed on shutdown
+func (d *DNSListener) track(conn net.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conns[conn] = struct{}{}
+}
+
+// untrack removes a closed TCP connection
+func (d *DNSListener) untrack(conn net.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.conns, conn)
+}
+
+// closeAll closes every open TCP connection
+func (d *DNSListener) closeAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for conn := range d.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestResolveAnyOnCNAME(t *testing.T) {
+	d, err := NewDNSListener(DNSListenerConfig{Name: "test", Records: []DNSRecord{
+		{Name: "www.example.test", Type: "CNAME", Values: []string{"app.example.test"}, TTL: time.Minute},
+		{Name: "app.example.test", Type: "A", Values: []string{"192.0.2.1"}, TTL: 10 * time.Second},
+	}}, NewLogger(LoggingConfig{Level: "error"}), testMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answers, rcode := d.resolve(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("www.example.test."),
+		Type:  dnsmessage.TypeALL,
+		Class: dnsmessage.ClassINET,
+	})
+	if rcode != dnsmessage.RCodeSuccess {
+		t.Fatalf("rcode %v", rcode)
+	}
+	var cnames, as int
+	for _, answer := range answers {
+		switch answer.Header.Type {
+		case dnsmessage.TypeCNAME:
+			cnames++
+			if answer.Header.TTL != 60 {
+				t.Errorf("CNAME TTL %d, want 60", answer.Header.TTL)
+			}
+		case dnsmessage.TypeA:
+			as++
+		}
+	}
+	if cnames != 1 || as != 1 {
+		t.Errorf("%d CNAME and %d A answers, want one of each", cnames, as)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxRecordedHeaderBytes bounds a header block the header recorder keeps.
+// Connections with a larger one are no longer recorded.
+const maxRecordedHeaderBytes = 64 << 10
+
+// maxRecordedBlocks is how many header blocks of a connection the header
+// recorder keeps waiting for an echo request to claim them
+const maxRecordedBlocks = 8
+
+// forwardingHeaders are the headers proxies add about the original request
+var forwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Prefix",
+	"X-Real-Ip",
+	"Via",
+}
+
+// echoResponse is what an echo endpoint answers
+type echoResponse struct {
+	Method        string            `json:"method"`
+	URI           string            `json:"uri"` // As sent in the request line
+	Proto         string            `json:"proto"`
+	Host          string            `json:"host"`
+	Path          string            `json:"path"`
+	Query         url.Values        `json:"query,omitempty"`
+	Headers       []echoHeader      `json:"headers"`
+	HeaderOrder   string            `json:"header_order"` // "received", or "sorted" when the wire order is unknown
+	ContentLength int64             `json:"content_length"`
+	Body          string            `json:"body"`
+	BodyEncoding  string            `json:"body_encoding,omitempty"` // "base64" for bodies that are not UTF-8
+	BodySize      int64             `json:"body_size"`
+	BodyTruncated bool              `json:"body_truncated,omitempty"`
+	RemoteAddr    string            `json:"remote_addr"`
+	LocalAddr     string            `json:"local_addr,omitempty"`
+	Forwarded     map[string]string `json:"forwarded,omitempty"`
+	ProxyProtocol *ProxyHeader      `json:"proxy_protocol,omitempty"`
+	TLS           *echoTLS          `json:"tls,omitempty"`
+	Server        echoServer        `json:"server"`
+	Timing        echoTiming        `json:"timing"`
+}
+
+type echoHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type echoTLS struct {
+	Version            string            `json:"version"`
+	CipherSuite        string            `json:"cipher_suite"`
+	ServerName         string            `json:"server_name,omitempty"`
+	NegotiatedProtocol string            `json:"negotiated_protocol,omitempty"`
+	Resumed            bool              `json:"resumed"`
+	ClientCertificates []echoCertificate `json:"client_certificates,omitempty"`
+}
+
+type echoCertificate struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+type echoServer struct {
+	Hostname  string `json:"hostname"`
+	Pod       string `json:"pod"`
+	Namespace string `json:"namespace,omitempty"`
+	Node      string `json:"node,omitempty"`
+}
+
+type echoTiming struct {
+	ConnectionAccepted *time.Time `json:"connection_accepted,omitempty"`
+	RequestReceived    time.Time  `json:"request_received"` // When the handler started, after the request headers
+	BodyReadMS         float64    `json:"body_read_ms"`     // Time to read the request body
+	ElapsedMS          float64    `json:"elapsed_ms"`       // From request_received to this response, including delays
+}
+
+// echoRequest describes r as received by the backend
+func echoRequest(r *http.Request, cfg *EchoConfig, start time.Time) []byte {
+	resp := echoResponse{
+		Method:        r.Method,
+		URI:           r.RequestURI,
+		Proto:         r.Proto,
+		Host:          r.Host,
+		Path:          r.URL.Path,
+		Query:         r.URL.Query(),
+		ContentLength: r.ContentLength,
+		RemoteAddr:    r.RemoteAddr,
+		Server:        echoServer{Hostname: hostname, Pod: podName, Namespace: podNamespace, Node: nodeName},
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		resp.LocalAddr = addr.String()
+	}
+
+	resp.Headers, resp.HeaderOrder = receivedHeaders(r), "received"
+	if resp.Headers == nil {
+		resp.Headers, resp.HeaderOrder = sortedHeaders(r), "sorted"
+	}
+	for _, name := range forwardingHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			if resp.Forwarded == nil {
+				resp.Forwarded = make(map[string]string)
+			}
+			resp.Forwarded[name] = strings.Join(values, ", ")
+		}
+	}
+
+	if info := connInfoFrom(r.Context()); info != nil {
+		resp.Timing.ConnectionAccepted = &info.accepted
+		if pc, ok := findConn[*proxyConn](info.conn); ok {
+			resp.ProxyProtocol = pc.ProxyHeader()
+		}
+	}
+	if r.TLS != nil {
+		resp.TLS = describeTLS(r.TLS)
+	}
+
+	// Read the body, keeping only the start of it
+	bodyStart := time.Now()
+	var body bytes.Buffer
+	resp.BodySize, _ = io.Copy(&body, io.LimitReader(r.Body, int64(cfg.MaxBodySize)))
+	rest, _ := io.Copy(io.Discard, r.Body)
+	resp.BodySize += rest
+	resp.BodyTruncated = rest > 0
+	resp.Timing.BodyReadMS = float64(time.Since(bodyStart).Microseconds()) / 1000
+	if utf8.Valid(body.Bytes()) {
+		resp.Body = body.String()
+	} else {
+		resp.Body, resp.BodyEncoding = base64.StdEncoding.EncodeToString(body.Bytes()), "base64"
+	}
+
+	resp.Timing.RequestReceived = start
+	resp.Timing.ElapsedMS = float64(time.Since(start).Microseconds()) / 1000
+	data, _ := json.MarshalIndent(resp, "", "  ")
+	return append(data, '\n')
+}
+
+// receivedHeaders returns the headers of r in the order and case they were
+// received, or nil when they were not recorded
+func receivedHeaders(r *http.Request) []echoHeader {
+	info := connInfoFrom(r.Context())
+	if info == nil || r.ProtoMajor != 1 {
+		return nil
+	}
+	recorder, ok := findConn[*headerRecorder](info.conn)
+	if !ok {
+		return nil
+	}
+	block, ok := recorder.takeHeaders(r.Method + " " + r.RequestURI + " " + r.Proto + "\r\n")
+	if !ok {
+		return nil
+	}
+
+	headers := []echoHeader{}
+	for _, line := range strings.Split(block, "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		headers = append(headers, echoHeader{Name: name, Value: strings.TrimSpace(value)})
+	}
+	return headers
+}
+
+// sortedHeaders returns the headers of r sorted by name, with the Host header
+// that net/http moves to r.Host
+func sortedHeaders(r *http.Request) []echoHeader {
+	headers := []echoHeader{{Name: "Host", Value: r.Host}}
+	for _, name := range slices.Sorted(maps.Keys(r.Header)) {
+		for _, value := range r.Header[name] {
+			headers = append(headers, echoHeader{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// describeTLS summarizes the TLS state of a connection
+func describeTLS(state *tls.ConnectionState) *echoTLS {
+	t := &echoTLS{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		Resumed:            state.DidResume,
+	}
+	for _, cert := range state.PeerCertificates {
+		t.ClientCertificates = append(t.ClientCertificates, echoCertificate{
+			Subject:  cert.Subject.String(),
+			Issuer:   cert.Issuer.String(),
+			Serial:   cert.SerialNumber.String(),
+			NotAfter: cert.NotAfter,
+		})
+	}
+	return t
+}
+
+// headerRecordingListener records the header blocks its HTTP/1.x connections
+// receive so echo endpoints can report headers in their original order and
+// case, which net/http does not keep
+type headerRecordingListener struct {
+	net.Listener
+}
+
+func (l *headerRecordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &headerRecorder{Conn: conn}, nil
+}
+
+// What a headerRecorder is reading
+const (
+	readingHeaders   = iota // A request header block
+	readingBody             // remaining bytes of a body or chunk, then next
+	readingChunkSize        // The size line of a chunk
+	readingTrailers         // The trailer lines after the last chunk
+)
+
+// headerRecorder keeps the header blocks read from a connection until a
+// request claims them. It follows the request framing to skip the bodies.
+type headerRecorder struct {
+	net.Conn
+	mu        sync.Mutex
+	state     int
+	buf       []byte   // Header block or line being read
+	remaining int64    // Bytes left in readingBody
+	next      int      // State after readingBody
+	blocks    []string // Unclaimed header blocks, request line included, oldest first
+	checked   bool     // Whether the start of the connection was checked for HTTP/2
+	disabled  bool
+}
+
+// NetConn returns the recorded connection
+func (c *headerRecorder) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *headerRecorder) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.record(p[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// record follows the request framing through p
+func (c *headerRecorder) record(p []byte) {
+	for len(p) > 0 && !c.disabled {
+		switch c.state {
+		case readingHeaders:
+			from := max(0, len(c.buf)-3)
+			c.buf = append(c.buf, p...)
+			p = nil
+			if !c.checked && len(c.buf) >= 4 {
+				// h2c connections start with "PRI * HTTP/2.0" and carry no HTTP/1.x headers
+				c.checked = true
+				c.disabled = bytes.HasPrefix(c.buf, []byte("PRI "))
+			}
+			end := bytes.Index(c.buf[from:], []byte("\r\n\r\n"))
+			if end < 0 {
+				// Lost track of the framing, or the server rejects the request anyway
+				c.disabled = len(c.buf) > maxRecordedHeaderBytes
+				continue
+			}
+			end += from
+			p = bytes.Clone(c.buf[end+4:])
+			c.addBlock(string(bytes.TrimLeft(c.buf[:end], "\r\n")))
+			c.buf = c.buf[:0]
+		case readingBody:
+			n := int(min(c.remaining, int64(len(p))))
+			p, c.remaining = p[n:], c.remaining-int64(n)
+			if c.remaining == 0 {
+				c.state = c.next
+			}
+		case readingChunkSize, readingTrailers:
+			end := bytes.IndexByte(p, '\n')
+			if end < 0 {
+				c.buf = append(c.buf, p...)
+				p = nil
+				c.disabled = len(c.buf) > maxRecordedHeaderBytes
+				continue
+			}
+			c.buf = append(c.buf, p[:end+1]...)
+			p = p[end+1:]
+			line := strings.TrimRight(string(c.buf), "\r\n")
+			c.buf = c.buf[:0]
+			c.endLine(line)
+		}
+	}
+	if c.disabled {
+		c.buf, c.blocks = nil, nil
+	}
+}
+
+// addBlock keeps a header block and expects the body it announces
+func (c *headerRecorder) addBlock(block string) {
+	if len(c.blocks) == maxRecordedBlocks {
+		c.blocks = slices.Delete(c.blocks, 0, 1)
+	}
+	c.blocks = append(c.blocks, block)
+
+	header := parseHeaderLines(block)
+	switch {
+	case strings.Contains(strings.ToLower(header.Get("Transfer-Encoding")), "chunked"):
+		c.state = readingChunkSize
+	case header.Get("Content-Length") != "":
+		length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		c.disabled = err != nil || length < 0
+		c.state, c.remaining, c.next = readingBody, length, readingHeaders
+		if length == 0 {
+			c.state = readingHeaders
+		}
+	}
+}
+
+// endLine handles a line of a chunked body
+func (c *headerRecorder) endLine(line string) {
+	if c.state == readingTrailers {
+		if line == "" {
+			c.state = readingHeaders
+		}
+		return
+	}
+	size, _, _ := strings.Cut(line, ";")
+	length, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	switch {
+	case err != nil || length < 0:
+		c.disabled = true
+	case length == 0:
+		c.state = readingTrailers
+	default:
+		// The chunk and its CRLF
+		c.state, c.remaining, c.next = readingBody, length+2, readingChunkSize
+	}
+}
+
+// parseHeaderLines parses the header lines of a block, leniently
+func parseHeaderLines(block string) http.Header {
+	header := make(http.Header)
+	for _, line := range strings.Split(block, "\r\n")[1:] {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			header.Add(name, strings.TrimSpace(value))
+		}
+	}
+	return header
+}
+
+// takeHeaders returns the header lines following requestLine, which ends
+// with CRLF, and forgets the blocks received up to them
+func (c *headerRecorder) takeHeaders(requestLine string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, block := range c.blocks {
+		// Requests without headers have no CRLF after the request line
+		if block += "\r\n"; strings.HasPrefix(block, requestLine) {
+			c.blocks = slices.Delete(c.blocks, 0, i+1)
+			return strings.TrimSuffix(block[len(requestLine):], "\r\n"), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestHeaderRecorderSkipsBodies(t *testing.T) {
+	stream := "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 24\r\n\r\nGET /c HTTP/1.1\r\nX: body" +
+		"POST /b HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"14;ext\r\nGET /c HTTP/1.1\r\nX: \r\n0\r\nTrailer: 1\r\n\r\n" +
+		"GET /c HTTP/1.1\r\nzeta: 1\r\nAlpha: 2\r\n\r\n" +
+		"GET /d HTTP/1.1\r\n\r\n"
+
+	// Byte by byte, so every state sees its input split
+	c := &headerRecorder{}
+	for i := range len(stream) {
+		c.record([]byte{stream[i]})
+	}
+
+	tests := []struct {
+		requestLine string
+		want        string
+	}{
+		{"GET /c HTTP/1.1\r\n", "zeta: 1\r\nAlpha: 2"},
+		{"GET /d HTTP/1.1\r\n", ""},
+	}
+	for _, tt := range tests {
+		got, ok := c.takeHeaders(tt.requestLine)
+		if !ok || got != tt.want {
+			t.Errorf("takeHeaders(%q) = %q, %v, want %q", tt.requestLine, got, ok, tt.want)
+		}
+	}
+	if _, ok := c.takeHeaders("POST /a HTTP/1.1\r\n"); ok {
+		t.Errorf("blocks before a claimed one are kept")
+	}
+}
+
+func TestHeaderRecorderIgnoresHTTP2(t *testing.T) {
+	c := &headerRecorder{}
+	c.record([]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"))
+	if !c.disabled || len(c.blocks) != 0 {
+		t.Errorf("h2c connection recorded: disabled %v, %d blocks", c.disabled, len(c.blocks))
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expectations is the compiled form of an ExpectConfig
+type expectations struct {
+	config    *ExpectConfig
+	status    []statusRange
+	headers   []compiledHeaderRule
+	bodyRegex *regexp.Regexp
+	jsonRules []compiledJSONRule
+}
+
+// statusRange is an inclusive range of allowed status codes
+type statusRange struct {
+	min, max int
+}
+
+type compiledHeaderRule struct {
+	rule  HeaderRule
+	regex *regexp.Regexp
+}
+
+type compiledJSONRule struct {
+	rule     JSONRule
+	path     []jsonPathStep
+	expected interface{} // Normalized through encoding/json so it compares with decoded bodies
+}
+
+// jsonPathStep is a single object key or array index in a JSON path
+type jsonPathStep struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// expectationFailure describes a rule that a response did not satisfy
+type expectationFailure struct {
+	rule   string // Used as the error_type metric label
+	detail string
+}
+
+// expectationError is returned when a response fails one or more expectations
+type expectationError struct {
+	failures []expectationFailure
+	retry    bool
+}
+
+func (e *expectationError) Error() string {
+	parts := make([]string, 0, len(e.failures))
+	for _, f := range e.failures {
+		parts = append(parts, fmt.Sprintf("%s: %s", f.rule, f.detail))
+	}
+	return "expectation failed: " + strings.Join(parts, "; ")
+}
+
+// compileExpectations validates an ExpectConfig and prepares it for evaluation
+func compileExpectations(cfg *ExpectConfig) (*expectations, error) {
+	exp := &expectations{config: cfg}
+
+	for _, spec := range cfg.Status {
+		r, err := parseStatusRange(spec)
+		if err != nil {
+			return nil, err
+		}
+		exp.status = append(exp.status, r)
+	}
+
+	for i, rule := range cfg.Headers {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("header rule %d: name is required", i)
+		}
+		compiled := compiledHeaderRule{rule: rule}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("header rule %d: invalid regex: %w", i, err)
+			}
+			compiled.regex = re
+		}
+		exp.headers = append(exp.headers, compiled)
+	}
+
+	if cfg.BodyRegex != "" {
+		re, err := regexp.Compile(cfg.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_regex: %w", err)
+		}
+		exp.bodyRegex = re
+	}
+
+	for i, rule := range cfg.JSON {
+		path, err := parseJSONPath(rule.Path)
+		if err != nil {
+			return nil, fmt.Errorf("json rule %d: %w", i, err)
+		}
+		expected, err := normalizeJSON(rule.Equals)
+		if err != nil {
+			return nil, fmt.Errorf("json rule %d: %w", i, err)
+		}
+		exp.jsonRules = append(exp.jsonRules, compiledJSONRule{rule: rule, path: path, expected: expected})
+	}
+
+	if cfg.MinBodySize < 0 || cfg.MaxBodySize < 0 {
+		return nil, fmt.Errorf("body sizes cannot be negative")
+	}
+	if cfg.MaxBodySize > 0 && cfg.MinBodySize > cfg.MaxBodySize {
+		return nil, fmt.Errorf("min_body_size cannot exceed max_body_size")
+	}
+
+	return exp, nil
+}
+
+// check evaluates every rule against a response and returns the failures
+func (e *expectations) check(resp *http.Response, body []byte, latency time.Duration) []expectationFailure {
+	var failures []expectationFailure
+	fail := func(rule, format string, args ...interface{}) {
+		failures = append(failures, expectationFailure{rule: rule, detail: fmt.Sprintf(format, args...)})
+	}
+
+	if len(e.status) > 0 {
+		allowed := false
+		for _, r := range e.status {
+			if resp.StatusCode >= r.min && resp.StatusCode <= r.max {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			fail("expect_status", "got %d, want %v", resp.StatusCode, e.config.Status)
+		}
+	}
+
+	for _, h := range e.headers {
+		values, present := resp.Header[http.CanonicalHeaderKey(h.rule.Name)]
+		switch {
+		case h.rule.Absent && present:
+			fail("expect_header", "%s should be absent", h.rule.Name)
+		case h.rule.Absent:
+		case !present:
+			fail("expect_header", "%s is missing", h.rule.Name)
+		case h.regex != nil && !h.regex.MatchString(strings.Join(values, ", ")):
+			fail("expect_header", "%s: %q does not match %q", h.rule.Name, strings.Join(values, ", "), h.rule.Regex)
+		}
+	}
+
+	if e.config.BodyContains != "" && !bytes.Contains(body, []byte(e.config.BodyContains)) {
+		fail("expect_body_contains", "body does not contain %q", e.config.BodyContains)
+	}
+
+	if e.bodyRegex != nil && !e.bodyRegex.Match(body) {
+		fail("expect_body_regex", "body does not match %q", e.config.BodyRegex)
+	}
+
+	if len(e.jsonRules) > 0 {
+		var document interface{}
+		if err := json.Unmarshal(body, &document); err != nil {
+			fail("expect_json", "body is not valid JSON: %v", err)
+		} else {
+			for _, rule := range e.jsonRules {
+				actual, ok := lookupJSONPath(document, rule.path)
+				if !ok {
+					fail("expect_json", "%s not found", rule.rule.Path)
+				} else if !reflect.DeepEqual(actual, rule.expected) {
+					fail("expect_json", "%s = %v, want %v", rule.rule.Path, actual, rule.expected)
+				}
+			}
+		}
+	}
+
+	if e.config.MaxLatency > 0 && latency > e.config.MaxLatency {
+		fail("expect_latency", "took %v, max %v", latency, e.config.MaxLatency)
+	}
+
+	if len(body) < e.config.MinBodySize {
+		fail("expect_body_size", "%d bytes, min %d", len(body), e.config.MinBodySize)
+	}
+	if e.config.MaxBodySize > 0 && len(body) > e.config.MaxBodySize {
+		fail("expect_body_size", "%d bytes, max %d", len(body), e.config.MaxBodySize)
+	}
+
+	return failures
+}
+
+// parseStatusRange parses "200", "2xx" or "200-299"
+func parseStatusRange(spec string) (statusRange, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+
+	if len(spec) == 3 && strings.HasSuffix(spec, "xx") && spec[0] >= '1' && spec[0] <= '5' {
+		base := int(spec[0]-'0') * 100
+		return statusRange{min: base, max: base + 99}, nil
+	}
+
+	if lo, hi, ok := strings.Cut(spec, "-"); ok {
+		min, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		max, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || min > max {
+			return statusRange{}, fmt.Errorf("invalid status range: %s", spec)
+		}
+		return statusRange{min: min, max: max}, nil
+	}
+
+	code, err := strconv.Atoi(spec)
+	if err != nil {
+		return statusRange{}, fmt.Errorf("invalid status code: %s", spec)
+	}
+	return statusRange{min: code, max: code}, nil
+}
+
+// parseJSONPath parses a simple JSONPath such as $.items[0].name or $['a key']
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, fmt.Errorf("json path must start with $: %s", path)
+	}
+
+	var steps []jsonPathStep
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in json path: %s", path)
+			}
+			steps = append(steps, jsonPathStep{key: rest[:end]})
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key in json path: %s", path)
+			}
+			steps = append(steps, jsonPathStep{key: rest[2:end]})
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in json path: %s", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index in json path: %s", path)
+			}
+			steps = append(steps, jsonPathStep{index: index, isIdx: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid json path: %s", path)
+		}
+	}
+	return steps, nil
+}
+
+// lookupJSONPath walks a decoded JSON document
+func lookupJSONPath(document interface{}, path []jsonPathStep) (interface{}, bool) {
+	current := document
+	for _, step := range path {
+		if step.isIdx {
+			list, ok := current.([]interface{})
+			if !ok || step.index >= len(list) {
+				return nil, false
+			}
+			current = list[step.index]
+			continue
+		}
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[step.key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// normalizeJSON round-trips a YAML value through encoding/json so numbers and
+// maps have the same types as a decoded response body
+func normalizeJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("equals value cannot be represented as JSON: %w", err)
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
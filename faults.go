@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Outcomes of the fault decision made for each request to an endpoint
+const (
+	outcomeOK    = "ok"
+	outcomeDrop  = "drop"
+	outcomeIdle  = "idle"
+	outcomeReset = "reset"
+)
+
+// faultDecider picks the outcome of each request to an endpoint, at random
+// with the configured percentages or in order from its fault schedule
+type faultDecider struct {
+	endpoint BackendEndpoint
+	settings faultSettings // What the decisions depend on
+	rng      *lockedRand
+	requests atomic.Uint64 // Position in the schedule
+}
+
+// faultSettings are the endpoint settings that shape its fault decisions
+type faultSettings struct {
+	drop, idle, reset float64
+	pattern           string
+	every             int
+	fault             string
+}
+
+// newFaultSettings returns the fault settings of an endpoint
+func newFaultSettings(endpoint BackendEndpoint) faultSettings {
+	settings := faultSettings{drop: endpoint.DropPercent, idle: endpoint.IdlePercent}
+	if endpoint.HTTP2 != nil {
+		settings.reset = endpoint.HTTP2.ResetPercent
+	}
+	if schedule := endpoint.FaultSchedule; schedule != nil {
+		settings.pattern, settings.every, settings.fault = schedule.Pattern, schedule.Every, schedule.Fault
+	}
+	return settings
+}
+
+// newFaultDecider creates the decider of a validated endpoint
+func newFaultDecider(endpoint BackendEndpoint) *faultDecider {
+	return &faultDecider{
+		endpoint: endpoint,
+		settings: newFaultSettings(endpoint),
+		rng:      newLockedRand("endpoint " + endpoint.streamName()),
+	}
+}
+
+// next returns the outcome of the next request and the reason for logs.
+// Random stream resets only apply to HTTP/2 requests.
+func (d *faultDecider) next(http2 bool) (outcome, reason string) {
+	ep := d.endpoint
+	if schedule := ep.FaultSchedule; schedule != nil {
+		n := d.requests.Add(1)
+		i := int((n - 1) % uint64(len(schedule.outcomes)))
+		return schedule.outcomes[i], fmt.Sprintf("request %d, schedule position %d of %d", n, i+1, len(schedule.outcomes))
+	}
+
+	var resetPercent float64
+	if ep.HTTP2 != nil && http2 {
+		resetPercent = ep.HTTP2.ResetPercent
+	}
+	if ep.DropPercent == 0 && ep.IdlePercent == 0 && resetPercent == 0 {
+		return outcomeOK, ""
+	}
+
+	random := d.rng.Percent()
+	switch {
+	case random < ep.DropPercent:
+		return outcomeDrop, fmt.Sprintf("%.1f%% drop rate", ep.DropPercent)
+	case random < ep.DropPercent+ep.IdlePercent:
+		return outcomeIdle, fmt.Sprintf("%.1f%% idle rate", ep.IdlePercent)
+	case random < ep.DropPercent+ep.IdlePercent+resetPercent:
+		return outcomeReset, fmt.Sprintf("%.1f%% reset rate", resetPercent)
+	}
+	return outcomeOK, ""
+}
+
+// parseFaultPattern parses a pattern such as "OKDIOK" into outcomes. "OK" or
+// "." is a normal response, D a drop, I an idle connection and R a reset.
+func parseFaultPattern(pattern string) ([]string, error) {
+	var outcomes []string
+	rest := strings.ToUpper(pattern)
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "OK"):
+			outcomes = append(outcomes, outcomeOK)
+			rest = rest[2:]
+			continue
+		case rest[0] == '.':
+			outcomes = append(outcomes, outcomeOK)
+		case rest[0] == 'D':
+			outcomes = append(outcomes, outcomeDrop)
+		case rest[0] == 'I':
+			outcomes = append(outcomes, outcomeIdle)
+		case rest[0] == 'R':
+			outcomes = append(outcomes, outcomeReset)
+		case rest[0] == ' ' || rest[0] == ',':
+		default:
+			return nil, fmt.Errorf("invalid pattern %q: unexpected %q, use OK or . (normal), D (drop), I (idle) or R (reset)", pattern, rest[0])
+		}
+		rest = rest[1:]
+	}
+	if len(outcomes) == 0 {
+		return nil, fmt.Errorf("pattern has no outcomes")
+	}
+	return outcomes, nil
+}
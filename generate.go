@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand/v2"
+	"strconv"
+)
+
+// defaultPattern fills pattern bodies without a pattern of their own
+const defaultPattern = "abcdefghijklmnopqrstuvwxyz0123456789\n"
+
+// defaultRecordSize is the approximate size of generated JSON records
+const defaultRecordSize = 100
+
+// bodyGenerator produces the generated bodies of a backend or client endpoint
+type bodyGenerator struct {
+	config *GeneratedBodyConfig
+	sizes  *delaySampler // Size distribution, one nanosecond per byte; nil for a fixed size
+	rng    *lockedRand   // Seeds of random bodies
+}
+
+// newBodyGenerator creates a generator for a validated configuration
+func newBodyGenerator(cfg *GeneratedBodyConfig, stream string) *bodyGenerator {
+	g := &bodyGenerator{config: cfg, rng: newLockedRand(stream)}
+	if cfg.SizeDistribution != nil {
+		g.sizes = newDelaySampler(cfg.SizeDistribution.delays(), stream+" size")
+	}
+	return g
+}
+
+// next returns the next body and its exact size. The body is produced as it
+// is read, so large bodies are never held in memory.
+func (g *bodyGenerator) next() (io.Reader, int64) {
+	cfg := g.config
+	size := int64(cfg.Size)
+	if g.sizes != nil {
+		size = int64(g.sizes.sample())
+	}
+
+	switch cfg.Content {
+	case "random":
+		var seed [32]byte
+		for i := 0; i < len(seed); i += 8 {
+			binary.LittleEndian.PutUint64(seed[i:], g.rng.Uint64())
+		}
+		return io.LimitReader(rand.NewChaCha8(seed), size), size
+	case "json", "ndjson":
+		records := int64(cfg.Records)
+		if records == 0 {
+			records = max(1, size/int64(cfg.RecordSize))
+		}
+		r := &recordReader{records: records, recordSize: cfg.RecordSize, ndjson: cfg.Content == "ndjson"}
+		return r, r.size()
+	default:
+		return &patternReader{pattern: []byte(cfg.Pattern), left: size}, size
+	}
+}
+
+// patternReader repeats a pattern until left bytes have been read
+type patternReader struct {
+	pattern []byte
+	offset  int // Position in pattern of the next byte
+	left    int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], r.pattern[r.offset:])
+		n += copied
+		r.offset = (r.offset + copied) % len(r.pattern)
+	}
+	r.left -= int64(n)
+	return n, nil
+}
+
+// recordReader produces a JSON array, or newline-delimited JSON, of records
+// such as {"id":1,"name":"record-1","pad":"xxxx"} padded to recordSize bytes
+type recordReader struct {
+	records    int64
+	recordSize int
+	ndjson     bool
+	written    int64 // Records produced so far
+	started    bool  // Whether the opening bracket of the array was produced
+	buf        bytes.Buffer
+}
+
+// record appends record id, unpadded, to buf and returns the padding it needs
+func (r *recordReader) record(buf []byte, id int64) ([]byte, int) {
+	start := len(buf)
+	buf = append(buf, `{"id":`...)
+	buf = strconv.AppendInt(buf, id, 10)
+	buf = append(buf, `,"name":"record-`...)
+	buf = strconv.AppendInt(buf, id, 10)
+	buf = append(buf, `","pad":"`...)
+	unpadded := len(buf) - start + len(`"}`)
+	return buf, max(0, r.recordSize-unpadded)
+}
+
+// size returns the number of bytes the reader produces. Records whose ids
+// have the same number of digits have the same length, so only one record per
+// digit count is rendered.
+func (r *recordReader) size() int64 {
+	var total int64
+	for first := int64(1); first <= r.records; first *= 10 {
+		last := r.records
+		if first <= math.MaxInt64/10 {
+			last = min(last, first*10-1)
+		}
+		record, pad := r.record(nil, first)
+		total += (last - first + 1) * (int64(len(record)+pad+len(`"}`)) + 1) // Separator or newline
+		if last == r.records {
+			break
+		}
+	}
+	if !r.ndjson {
+		total++ // Brackets, minus the separator after the last record
+	}
+	return total
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	for r.buf.Len() < len(p) && (r.written < r.records || !r.started) {
+		if !r.ndjson && !r.started {
+			r.buf.WriteByte('[')
+		}
+		r.started = true
+		if r.written == r.records {
+			break
+		}
+
+		r.written++
+		record, pad := r.record(r.buf.AvailableBuffer(), r.written)
+		r.buf.Write(record)
+		r.buf.Write(bytes.Repeat([]byte{'x'}, pad))
+		r.buf.WriteString(`"}`)
+		switch {
+		case r.ndjson:
+			r.buf.WriteByte('\n')
+		case r.written < r.records:
+			r.buf.WriteByte(',')
+		default:
+			r.buf.WriteByte(']')
+		}
+	}
+	if r.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.buf.Read(p)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestRecordReaderSize(t *testing.T) {
+	tests := []struct {
+		records    int64
+		recordSize int
+	}{
+		{1, 100},
+		{9, 10}, // Records longer than record_size
+		{10, 100},
+		{1234, 100},
+		{1234, 40},
+	}
+	for _, tt := range tests {
+		for _, ndjson := range []bool{false, true} {
+			r := &recordReader{records: tt.records, recordSize: tt.recordSize, ndjson: ndjson}
+			want := r.size()
+			got, err := io.Copy(io.Discard, r)
+			if err != nil || got != want {
+				t.Errorf("%d records of %d bytes, ndjson %v: read %d bytes (%v), size %d", tt.records, tt.recordSize, ndjson, got, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// latencyHistogram records durations with microsecond resolution in log-linear
+// buckets: every power of two is split into 128 sub-buckets, so a percentile is
+// never off by more than 1% however wide the range of recorded values. Unlike
+// the Prometheus histograms it needs no bucket layout chosen in advance.
+// It is not safe for concurrent use.
+type latencyHistogram struct {
+	counts []int64
+	count  int64
+	max    time.Duration
+}
+
+const (
+	histogramSubBits    = 7
+	histogramSubBuckets = 1 << histogramSubBits
+)
+
+// histogramIndex returns the bucket holding v
+func histogramIndex(v uint64) int {
+	if v < 2*histogramSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histogramSubBits - 1
+	return histogramSubBuckets*(shift+1) + int(v>>shift) - histogramSubBuckets
+}
+
+// histogramUpperBound returns the largest value held by bucket i
+func histogramUpperBound(i int) uint64 {
+	if i < 2*histogramSubBuckets {
+		return uint64(i)
+	}
+	shift := i/histogramSubBuckets - 1
+	base := uint64(i%histogramSubBuckets+histogramSubBuckets) << shift
+	return base + (1 << shift) - 1
+}
+
+// record adds a duration to the histogram
+func (h *latencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := histogramIndex(uint64(d / time.Microsecond))
+	if i >= len(h.counts) {
+		counts := make([]int64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// percentile returns the value below which a fraction q (0-1) of the recorded durations fall
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank >= h.count {
+		return h.max
+	}
+
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			d := time.Duration(histogramUpperBound(i)) * time.Microsecond
+			return min(d, h.max)
+		}
+	}
+	return h.max
+}
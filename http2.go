@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// configureServerProtocols applies the HTTP/2 settings, which may be nil, to the
+// backend server and its TLS configuration, which may be nil too. faults puts
+// the HTTP/2 fault layer on every HTTP/2 connection.
+func configureServerProtocols(server *http.Server, tlsConfig *tls.Config, cfg *HTTP2ServerConfig, faults bool) error {
+	if cfg == nil {
+		cfg = &HTTP2ServerConfig{}
+	}
+
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(!cfg.Disable)
+	server.Protocols.SetUnencryptedHTTP2(cfg.H2C)
+	if cfg.Disable {
+		if tlsConfig != nil {
+			tlsConfig.NextProtos = []string{"http/1.1"}
+		}
+		return nil
+	}
+
+	server.HTTP2 = &http.HTTP2Config{
+		MaxConcurrentStreams:          cfg.MaxConcurrentStreams,
+		MaxReceiveBufferPerStream:     cfg.StreamWindowSize,
+		MaxReceiveBufferPerConnection: cfg.ConnWindowSize,
+		MaxReadFrameSize:              cfg.MaxReadFrameSize,
+	}
+	// On HTTP/2 connections the idle timeout is enforced with a GOAWAY
+	server.IdleTimeout = cfg.IdleTimeout
+	if !faults {
+		return nil
+	}
+
+	// Serve HTTP/2 with golang.org/x/net/http2 instead of the bundled copy so
+	// connections can be wrapped for fault injection. ConfigureServer reads
+	// server.HTTP2 and server.IdleTimeout and hooks into graceful shutdown.
+	h2 := &http2.Server{}
+	if err := http2.ConfigureServer(server, h2); err != nil {
+		return err
+	}
+	server.TLSNextProto["h2"] = func(hs *http.Server, c *tls.Conn, h http.Handler) {
+		fc := newH2Conn(c, false)
+		serveHTTP2(h2, hs, h, fc, h2TLSConn{fc, c})
+	}
+	server.TLSNextProto["unencrypted_http2"] = func(hs *http.Server, c *tls.Conn, h http.Handler) {
+		// net/http has already read the client preface of h2c connections
+		nc, ok := c.NetConn().(interface{ UnencryptedNetConn() net.Conn })
+		if !ok {
+			c.Close()
+			return
+		}
+		fc := newH2Conn(nc.UnencryptedNetConn(), true)
+		serveHTTP2(h2, hs, h, fc, fc)
+	}
+	return nil
+}
+
+// serveHTTP2 serves an HTTP/2 connection handed over by net/http. conn is fc,
+// or fc with the TLS state of the connection.
+func serveHTTP2(h2 *http2.Server, hs *http.Server, h http.Handler, fc *h2Conn, conn net.Conn) {
+	ctx := context.Background()
+	if bc, ok := h.(interface{ BaseContext() context.Context }); ok {
+		ctx = bc.BaseContext()
+	}
+	h2.ServeConn(conn, &http2.ServeConnOpts{
+		Context:          context.WithValue(ctx, h2ConnKey{}, fc),
+		Handler:          streamIDHandler(h),
+		BaseConfig:       hs,
+		SawClientPreface: fc.prefaceLeft == 0,
+	})
+}
+
+// hasHTTP2Faults reports whether any of endpoints injects HTTP/2 faults
+func hasHTTP2Faults(endpoints ...BackendEndpoint) bool {
+	return slices.ContainsFunc(endpoints, func(ep BackendEndpoint) bool { return ep.HTTP2 != nil })
+}
+
+// limitConnectionAge asks the client to stop using connections older than
+// maxAge. On HTTP/2 "Connection: close" makes the server send a GOAWAY and let
+// the open streams finish; on HTTP/1.1 the connection is closed after the response.
+func limitConnectionAge(w http.ResponseWriter, r *http.Request, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	if info := connInfoFrom(r.Context()); info != nil && time.Since(info.accepted) >= maxAge {
+		w.Header().Set("Connection", "close")
+	}
+}
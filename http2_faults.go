@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP/2 frame layout (RFC 9113 section 4.1)
+const (
+	frameHeaderLen = 9
+
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	frameRSTStream    = 0x3
+	framePing         = 0x6
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// h2ConnKey is the context key holding the h2Conn a request arrived on
+type h2ConnKey struct{}
+
+// h2StreamKey is the context key holding the HTTP/2 stream ID of a request
+type h2StreamKey struct{}
+
+// h2StreamHeader carries the stream ID of a request from h2Conn to the
+// handler. The server does not expose stream IDs, so h2Conn appends it to the
+// header block of each request; streamIDHandler moves it to the context.
+const h2StreamHeader = "x-test-backend-h2-stream"
+
+// h2Conn sits between an HTTP/2 connection and the server to inject faults
+// the server cannot be asked for: it rewrites RST_STREAM error codes, drops
+// WINDOW_UPDATE frames and leaves PINGs unanswered. Client header blocks are
+// decoded to count requests and tag each one with its stream.
+type h2Conn struct {
+	net.Conn
+
+	// Client to server, only used by the server's reader
+	prefaceLeft  int    // Bytes of the client preface still to pass through
+	in           []byte // Received bytes not yet parsed into frames
+	out          []byte // Parsed frames waiting to be read by the server
+	readErr      error  // Error to return once out is drained
+	readBuf      []byte // Reused for every read from the connection
+	decoder      *hpack.Decoder
+	headerStream uint32 // Stream whose header block is being decoded
+	method       string // :method of that header block, empty for trailers
+
+	// Server to client
+	wmu     sync.Mutex
+	pending []byte // Partial frame from the previous write
+
+	mu          sync.Mutex
+	resetCodes  map[uint32]http2.ErrCode // Stream -> code for the RST_STREAM of an aborted handler
+	withhold    map[uint32]bool          // Streams whose WINDOW_UPDATEs are dropped
+	opened      int                      // Streams opened by the client so far
+	ignorePings bool
+}
+
+// h2TLSConn is an h2Conn over TLS, exposing the TLS state to the server
+type h2TLSConn struct {
+	*h2Conn
+	tlsConn *tls.Conn
+}
+
+func (c h2TLSConn) ConnectionState() tls.ConnectionState {
+	return c.tlsConn.ConnectionState()
+}
+
+// newH2Conn wraps conn. sawPreface tells whether the client preface was
+// already consumed, as it is for h2c with prior knowledge.
+func newH2Conn(conn net.Conn, sawPreface bool) *h2Conn {
+	c := &h2Conn{
+		Conn:       conn,
+		resetCodes: make(map[uint32]http2.ErrCode),
+		withhold:   make(map[uint32]bool),
+	}
+	if !sawPreface {
+		c.prefaceLeft = len(http2.ClientPreface)
+	}
+	// The server advertises the default 4096 byte header table
+	c.decoder = hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+		if f.Name == ":method" {
+			c.method = f.Value
+		}
+	})
+	return c
+}
+
+// h2ConnFrom returns the h2Conn serving r, or nil for HTTP/1.x requests
+func h2ConnFrom(r *http.Request) *h2Conn {
+	c, _ := r.Context().Value(h2ConnKey{}).(*h2Conn)
+	return c
+}
+
+// streamIDHandler moves the stream ID h2Conn adds to each request to its
+// context, so it reaches neither the endpoints nor the logs
+func streamIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The client may send the header too; h2Conn adds its own last
+		if values := r.Header.Values(h2StreamHeader); len(values) > 0 {
+			r.Header.Del(h2StreamHeader)
+			if id, err := strconv.ParseUint(values[len(values)-1], 10, 32); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), h2StreamKey{}, uint32(id)))
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// streamID returns the HTTP/2 stream of r, or 0 when it is unknown
+func streamID(r *http.Request) uint32 {
+	id, _ := r.Context().Value(h2StreamKey{}).(uint32)
+	return id
+}
+
+// Read passes the client frames to the server, minus the PINGs being ignored
+func (c *h2Conn) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.readBuf == nil {
+			c.readBuf = make([]byte, 32*1024)
+		}
+		n, err := c.Conn.Read(c.readBuf)
+		c.in = append(c.in, c.readBuf[:n]...)
+		c.parseClientFrames()
+		c.readErr = err
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// parseClientFrames moves the complete frames in c.in to c.out
+func (c *h2Conn) parseClientFrames() {
+	if c.prefaceLeft > 0 {
+		n := min(c.prefaceLeft, len(c.in))
+		c.out = append(c.out, c.in[:n]...)
+		c.in = c.in[n:]
+		c.prefaceLeft -= n
+		if c.prefaceLeft > 0 {
+			return
+		}
+	}
+
+	for len(c.in) >= frameHeaderLen {
+		length := int(c.in[0])<<16 | int(c.in[1])<<8 | int(c.in[2])
+		if len(c.in) < frameHeaderLen+length {
+			return
+		}
+		frame := c.in[:frameHeaderLen+length]
+		c.in = c.in[frameHeaderLen+length:]
+		ftype, flags := frame[3], frame[4]
+		stream := binary.BigEndian.Uint32(frame[5:9]) & 0x7fffffff
+		payload := frame[frameHeaderLen:]
+
+		switch ftype {
+		case framePing:
+			c.mu.Lock()
+			ignore := c.ignorePings && flags&flagAck == 0
+			c.mu.Unlock()
+			if ignore {
+				continue
+			}
+		case frameHeaders:
+			if flags&flagPadded != 0 && len(payload) > 0 {
+				pad := int(payload[0])
+				payload = payload[1:]
+				if pad <= len(payload) {
+					payload = payload[:len(payload)-pad]
+				}
+			}
+			if flags&flagPriority != 0 && len(payload) >= 5 {
+				payload = payload[5:]
+			}
+			c.headerStream = stream
+			c.method = ""
+			if c.decodeHeaders(payload, flags&flagEndHeaders != 0) {
+				c.appendStreamHeader(frame)
+				continue
+			}
+		case frameContinuation:
+			if c.decodeHeaders(payload, flags&flagEndHeaders != 0) {
+				c.appendStreamHeader(frame)
+				continue
+			}
+		case frameRSTStream:
+			c.forgetStream(stream)
+		}
+		c.out = append(c.out, frame...)
+	}
+	// Do not keep the backing array of a large frame alive
+	c.in = append([]byte(nil), c.in...)
+}
+
+// decodeHeaders feeds a header block fragment to the decoder and reports
+// whether it ends the header block of a request. Decoding errors are left to
+// the server, which will close the connection.
+func (c *h2Conn) decodeHeaders(fragment []byte, end bool) bool {
+	if _, err := c.decoder.Write(fragment); err != nil || !end {
+		return false
+	}
+	if c.decoder.Close() != nil || c.method == "" {
+		// Trailers
+		return false
+	}
+	c.mu.Lock()
+	c.opened++
+	c.mu.Unlock()
+	return true
+}
+
+// appendStreamHeader passes frame, the end of a request header block, to the
+// server followed by a CONTINUATION frame holding h2StreamHeader. The field is
+// never indexed so the HPACK tables of both sides stay in step.
+func (c *h2Conn) appendStreamHeader(frame []byte) {
+	var block bytes.Buffer
+	hpack.NewEncoder(&block).WriteField(hpack.HeaderField{
+		Name:      h2StreamHeader,
+		Value:     strconv.FormatUint(uint64(c.headerStream), 10),
+		Sensitive: true,
+	})
+
+	frame[4] &^= flagEndHeaders
+	c.out = append(c.out, frame...)
+	header := make([]byte, frameHeaderLen)
+	header[0], header[1], header[2] = 0, 0, byte(block.Len())
+	header[3], header[4] = frameContinuation, flagEndHeaders
+	binary.BigEndian.PutUint32(header[5:9], c.headerStream)
+	c.out = append(c.out, header...)
+	c.out = append(c.out, block.Bytes()...)
+}
+
+// Write passes the server frames to the client, rewriting the code of
+// RST_STREAM frames and dropping withheld WINDOW_UPDATE frames
+func (c *h2Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	buf := append(c.pending, p...)
+	out := make([]byte, 0, len(buf))
+	for len(buf) >= frameHeaderLen {
+		length := int(buf[0])<<16 | int(buf[1])<<8 | int(buf[2])
+		if len(buf) < frameHeaderLen+length {
+			break
+		}
+		frame := buf[:frameHeaderLen+length]
+		buf = buf[frameHeaderLen+length:]
+		if c.filterServerFrame(frame) {
+			out = append(out, frame...)
+		}
+	}
+	c.pending = append([]byte(nil), buf...)
+
+	if len(out) > 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// filterServerFrame rewrites frame in place and reports whether it is sent
+func (c *h2Conn) filterServerFrame(frame []byte) bool {
+	ftype, flags := frame[3], frame[4]
+	stream := binary.BigEndian.Uint32(frame[5:9]) & 0x7fffffff
+	payload := frame[frameHeaderLen:]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch ftype {
+	case frameRSTStream:
+		// Handlers aborted with http.ErrAbortHandler reset their stream with INTERNAL_ERROR
+		if code, ok := c.resetCodes[stream]; ok && len(payload) == 4 && http2.ErrCode(binary.BigEndian.Uint32(payload)) == http2.ErrCodeInternal {
+			binary.BigEndian.PutUint32(payload, uint32(code))
+		}
+		c.forgetStreamLocked(stream)
+	case frameWindowUpdate:
+		if stream != 0 && c.withhold[stream] {
+			return false
+		}
+	case frameData, frameHeaders:
+		if flags&flagEndStream != 0 {
+			c.forgetStreamLocked(stream)
+		}
+	}
+	return true
+}
+
+// forgetStream drops the faults of a stream that has ended
+func (c *h2Conn) forgetStream(stream uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forgetStreamLocked(stream)
+}
+
+// forgetStreamLocked is forgetStream with c.mu held
+func (c *h2Conn) forgetStreamLocked(stream uint32) {
+	delete(c.resetCodes, stream)
+	delete(c.withhold, stream)
+}
+
+// setResetCode sets the RST_STREAM code used when the handler for r aborts
+func (c *h2Conn) setResetCode(r *http.Request, code http2.ErrCode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stream := streamID(r); stream != 0 {
+		c.resetCodes[stream] = code
+	}
+}
+
+// withholdWindowUpdates stops flow control credit for the body of r
+func (c *h2Conn) withholdWindowUpdates(r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stream := streamID(r); stream != 0 {
+		c.withhold[stream] = true
+	}
+}
+
+// stopAnsweringPings makes the connection ignore PINGs from now on and
+// reports whether it was answering them until now
+func (c *h2Conn) stopAnsweringPings() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ignorePings {
+		return false
+	}
+	c.ignorePings = true
+	return true
+}
+
+// streamsOpened returns the number of streams the client has opened
+func (c *h2Conn) streamsOpened() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opened
+}
+
+// parseHTTP2ErrCode parses an HTTP/2 error code name such as CANCEL
+func parseHTTP2ErrCode(name string) (http2.ErrCode, error) {
+	for code := http2.ErrCodeNo; code <= http2.ErrCodeHTTP11Required; code++ {
+		if strings.EqualFold(code.String(), name) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown HTTP/2 error code: %s", name)
+}
+
+// applyHTTP2Faults injects the connection-level HTTP/2 faults of an endpoint
+// into the connection serving r. HTTP/1.x requests are left alone.
+func (b *Backend) applyHTTP2Faults(w http.ResponseWriter, r *http.Request, endpoint BackendEndpoint) {
+	conn := h2ConnFrom(r)
+	if conn == nil || endpoint.HTTP2 == nil {
+		return
+	}
+	faults := endpoint.HTTP2
+
+	if faults.IgnorePings && conn.stopAnsweringPings() {
+		b.logger.Warn("Ignoring PINGs on the connection from %s", r.RemoteAddr)
+		b.metrics.BackendHTTP2FaultsTotal.WithLabelValues(r.URL.Path, r.Method, "ignore_pings").Inc()
+	}
+
+	if faults.GoawayAfterStreams > 0 && conn.streamsOpened() >= faults.GoawayAfterStreams {
+		// The server answers this stream and sends GOAWAY, letting open streams finish
+		b.logger.Warn("Sending GOAWAY to %s after %d streams", r.RemoteAddr, conn.streamsOpened())
+		b.metrics.BackendHTTP2FaultsTotal.WithLabelValues(r.URL.Path, r.Method, "goaway").Inc()
+		w.Header().Set("Connection", "close")
+	}
+
+	if faults.WithholdWindowUpdates {
+		b.logger.Warn("Withholding WINDOW_UPDATE for %s %s", r.Method, r.URL.Path)
+		b.metrics.BackendHTTP2FaultsTotal.WithLabelValues(r.URL.Path, r.Method, "withhold_window_update").Inc()
+		conn.withholdWindowUpdates(r)
+		// Consume the body so the client runs out of window instead of the server buffer
+		io.Copy(io.Discard, r.Body)
+	}
+}
+
+// resetStream aborts the request with RST_STREAM, using the endpoint's
+// reset code, without affecting other streams on the connection. HTTP/1.x
+// connections are closed instead.
+func (b *Backend) resetStream(r *http.Request, endpoint BackendEndpoint) {
+	if r.ProtoMajor != 2 {
+		panic(http.ErrAbortHandler)
+	}
+	code := http2.ErrCodeInternal
+	if endpoint.HTTP2 != nil {
+		code, _ = parseHTTP2ErrCode(endpoint.HTTP2.ResetCode)
+	}
+	if conn := h2ConnFrom(r); conn != nil {
+		conn.setResetCode(r, code)
+	}
+	b.logger.Debug("Resetting stream for %s %s with %s", r.Method, r.URL.Path, code)
+	b.metrics.BackendHTTP2FaultsTotal.WithLabelValues(r.URL.Path, r.Method, "rst_stream").Inc()
+	panic(http.ErrAbortHandler)
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -14,6 +16,7 @@ import (
 func main() {
 	// Parse command-line flags
 	configFile := flag.String("config", "config/config.yaml", "Path to configuration file")
+	watchInterval := flag.Duration("watch", 5*time.Second, "How often to check the configuration file for changes (0 = disabled, SIGHUP still reloads)")
 	flag.Parse()
 
 	// Load configuration
@@ -32,58 +35,93 @@ func main() {
 	metrics := NewMetrics()
 	logger.Info("Prometheus metrics initialized")
 
+	// Seed fault decisions; the seed is logged so a run can be repeated
+	logger.Info("Random seed: %d", seedRandom(config.Seed))
+
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Error channel for component errors
 	errChan := make(chan error, 2)
 
 	// Start components based on configuration type
-	switch config.Type {
-	case "client":
-		go runClient(ctx, config, logger, metrics, errChan)
+	var client *Client
+	var backend *Backend
+
+	var clientDone chan struct{}
+
+	if config.Type == "client" || config.Type == "both" {
+		client = NewClient(config.Client, logger, metrics)
+		clientDone = make(chan struct{})
+		go func() {
+			defer close(clientDone)
+			runClient(ctx, client, errChan)
+		}()
+	}
+
+	if config.Type == "backend" || config.Type == "both" {
+		backend = NewBackend(config.Backend, logger, metrics)
 
-	case "backend":
-		go runBackend(ctx, config, logger, metrics, errChan)
+		// Add metrics endpoint to backend
+		backend.metricsHandler = promhttp.Handler()
 
-	case "both":
-		go runClient(ctx, config, logger, metrics, errChan)
-		go runBackend(ctx, config, logger, metrics, errChan)
+		go runBackend(ctx, backend, errChan)
 	}
 
-	// Wait for shutdown signal or error
-	select {
-	case sig := <-sigChan:
-		logger.Info("Received signal: %v", sig)
-		cancel()
-	case err := <-errChan:
-		logger.Error("Component error: %v", err)
-		cancel()
+	// Reload configuration on SIGHUP and when the file changes
+	reloader := NewReloader(*configFile, config, client, backend, logger)
+	go reloader.Watch(ctx, *watchInterval)
+
+	// Wait for shutdown signal, error, or the end of a run with thresholds
+	exitCode := 0
+	for running := true; running; {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				go reloader.Reload("SIGHUP")
+				continue
+			}
+			logger.Info("Received signal: %v", sig)
+			cancel()
+			running = false
+		case err := <-errChan:
+			var complete *runCompleteError
+			if errors.As(err, &complete) {
+				logger.Info("Client %v", complete)
+				exitCode = complete.exitCode
+			} else {
+				logger.Error("Component error: %v", err)
+			}
+			cancel()
+			running = false
+		}
 	}
 
 	logger.Info("Shutting down gracefully...")
+
+	// Let the client write its run summary before exiting
+	if clientDone != nil {
+		<-clientDone
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
 }
 
 // runClient starts the HTTP client component
-func runClient(ctx context.Context, config *Config, logger *Logger, metrics *Metrics, errChan chan<- error) {
-	client := NewClient(config.Client, logger, metrics)
+func runClient(ctx context.Context, client *Client, errChan chan<- error) {
 	if err := client.Run(ctx); err != nil && err != context.Canceled {
 		errChan <- fmt.Errorf("client error: %w", err)
 	}
 }
 
 // runBackend starts the HTTP backend server component
-func runBackend(ctx context.Context, config *Config, logger *Logger, metrics *Metrics, errChan chan<- error) {
-	backend := NewBackend(config.Backend, logger, metrics)
-	
-	// Add metrics endpoint to backend
-	backend.metricsHandler = promhttp.Handler()
-	
+func runBackend(ctx context.Context, backend *Backend, errChan chan<- error) {
 	if err := backend.Run(ctx); err != nil && err != context.Canceled {
 		errChan <- fmt.Errorf("backend error: %w", err)
 	}
@@ -16,6 +16,23 @@ type Metrics struct {
 	ClientTLSDuration       *prometheus.HistogramVec
 	ClientTTFBDuration      *prometheus.HistogramVec
 	ClientRetries           *prometheus.CounterVec
+	ClientTLSInfo           *prometheus.GaugeVec
+	ClientTLSCertExpiryDays *prometheus.GaugeVec
+	ClientConnectionsTotal  *prometheus.CounterVec
+	ClientConnReuseRatio    *prometheus.GaugeVec
+	ClientOpenConnections   *prometheus.GaugeVec
+	ClientConnAgeAtReuse    *prometheus.HistogramVec
+	ClientIdleConnReturns   *prometheus.CounterVec
+	ClientDNSLookups        *prometheus.CounterVec
+	ClientDNSAddresses      *prometheus.GaugeVec
+	ClientConnectedAddress  *prometheus.GaugeVec
+
+	// TCP client metrics
+	TCPClientProbesTotal       *prometheus.CounterVec
+	TCPClientConnectDuration   *prometheus.HistogramVec
+	TCPClientFirstByteDuration *prometheus.HistogramVec
+	TCPClientCloseTotal        *prometheus.CounterVec
+	TCPClientBytesReceived     *prometheus.HistogramVec
 
 	// Backend metrics
 	BackendRequestsTotal    *prometheus.CounterVec
@@ -24,6 +41,25 @@ type Metrics struct {
 	BackendDroppedTotal     *prometheus.CounterVec
 	BackendIdledTotal       *prometheus.CounterVec
 	BackendIdleDuration     *prometheus.HistogramVec
+	BackendTLSHandshakeFailures *prometheus.CounterVec
+	BackendHTTP2FaultsTotal     *prometheus.CounterVec
+	BackendTruncatedResponses   *prometheus.CounterVec
+	BackendSampledDelay         *prometheus.HistogramVec
+	BackendScenarioFailures     *prometheus.CounterVec
+
+	// TCP backend metrics
+	TCPBackendConnectionsTotal   *prometheus.CounterVec
+	TCPBackendActiveConnections  *prometheus.GaugeVec
+	TCPBackendConnectionDuration *prometheus.HistogramVec
+	TCPBackendBytesTotal         *prometheus.CounterVec
+	TCPBackendIdleDuration       *prometheus.HistogramVec
+
+	// DNS backend metrics
+	DNSBackendQueriesTotal *prometheus.CounterVec
+	DNSBackendFaultsTotal  *prometheus.CounterVec
+
+	// Network shaping metrics
+	ShapingStallsTotal *prometheus.CounterVec
 }
 
 // NewMetrics creates and registers all Prometheus metrics
@@ -35,7 +71,7 @@ func NewMetrics() *Metrics {
 				Name: "http_client_requests_total",
 				Help: "Total number of HTTP requests made by the client",
 			},
-			[]string{"endpoint", "method", "status_code"},
+			[]string{"endpoint", "method", "status_code", "protocol"},
 		),
 		ClientRequestDuration: promauto.NewHistogramVec(
 			prometheus.HistogramOpts{
@@ -91,6 +127,117 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"endpoint", "method"},
 		),
+		ClientTLSInfo: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "http_client_tls_connection_info",
+				Help: "TLS parameters negotiated by the most recent handshake (value is always 1)",
+			},
+			[]string{"endpoint", "version", "cipher", "alpn"},
+		),
+		ClientTLSCertExpiryDays: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "http_client_tls_peer_cert_expiry_days",
+				Help: "Days until each certificate in the peer chain expires (negative once expired)",
+			},
+			[]string{"endpoint", "position", "subject", "issuer"},
+		),
+		ClientConnectionsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_client_connections_total",
+				Help: "Connections obtained for requests, new or reused from the idle pool",
+			},
+			[]string{"endpoint", "reused"},
+		),
+		ClientConnReuseRatio: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "http_client_connection_reuse_ratio",
+				Help: "Fraction of requests that reused a pooled connection",
+			},
+			[]string{"endpoint"},
+		),
+		ClientOpenConnections: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "http_client_open_connections",
+				Help: "Currently open client connections",
+			},
+			[]string{"endpoint"},
+		),
+		ClientConnAgeAtReuse: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_client_connection_age_at_reuse_seconds",
+				Help:    "Age of pooled connections when they are reused",
+				Buckets: []float64{.1, .5, 1, 5, 10, 30, 60, 120, 300, 600, 1800},
+			},
+			[]string{"endpoint"},
+		),
+		ClientIdleConnReturns: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_client_idle_conn_returns_total",
+				Help: "Connections returned after a response, by whether the idle pool kept them",
+			},
+			[]string{"endpoint", "outcome"},
+		),
+		ClientDNSLookups: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_client_dns_lookups_total",
+				Help: "DNS lookups by result (success, dns_nxdomain, dns_timeout, dns_servfail, dns_failed)",
+			},
+			[]string{"endpoint", "result"},
+		),
+		ClientDNSAddresses: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "http_client_dns_resolved_addresses",
+				Help: "Number of addresses returned by the latest successful lookup",
+			},
+			[]string{"endpoint"},
+		),
+		ClientConnectedAddress: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "http_client_connected_address_info",
+				Help: "Address the latest new connection was opened to, value 1",
+			},
+			[]string{"endpoint", "address"},
+		),
+
+		// TCP client metrics
+		TCPClientProbesTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tcp_client_probes_total",
+				Help: "Total number of TCP probes by outcome",
+			},
+			[]string{"endpoint", "outcome"},
+		),
+		TCPClientConnectDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "tcp_client_connect_duration_seconds",
+				Help:    "TCP probe connect duration in seconds",
+				Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+			},
+			[]string{"endpoint"},
+		),
+		TCPClientFirstByteDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "tcp_client_first_byte_duration_seconds",
+				Help:    "Time from dial to the first received byte in seconds",
+				Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+			},
+			[]string{"endpoint"},
+		),
+		TCPClientCloseTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tcp_client_close_total",
+				Help: "How TCP probe connections ended (fin, rst, timeout, local, error)",
+			},
+			[]string{"endpoint", "close_type"},
+		),
+		TCPClientBytesReceived: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "tcp_client_received_bytes",
+				Help:    "Bytes received per TCP probe",
+				Buckets: []float64{0, 10, 100, 1000, 10000, 65536},
+			},
+			[]string{"endpoint"},
+		),
 
 		// Backend metrics
 		BackendRequestsTotal: promauto.NewCounterVec(
@@ -98,7 +245,7 @@ func NewMetrics() *Metrics {
 				Name: "http_backend_requests_total",
 				Help: "Total number of HTTP requests received by the backend",
 			},
-			[]string{"path", "method", "status_code"},
+			[]string{"path", "method", "status_code", "protocol"},
 		),
 		BackendRequestDuration: promauto.NewHistogramVec(
 			prometheus.HistogramOpts{
@@ -112,7 +259,7 @@ func NewMetrics() *Metrics {
 			prometheus.HistogramOpts{
 				Name:    "http_backend_response_size_bytes",
 				Help:    "HTTP backend response size in bytes",
-				Buckets: []float64{10, 100, 1000, 10000, 100000, 1000000},
+				Buckets: []float64{10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000, 1000000000},
 			},
 			[]string{"path", "method"},
 		),
@@ -138,5 +285,105 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"path", "method"},
 		),
+		BackendTLSHandshakeFailures: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_backend_tls_handshake_failures_total",
+				Help: "Total number of failed TLS handshakes on backend listeners",
+			},
+			[]string{"listener", "reason"},
+		),
+		BackendHTTP2FaultsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_backend_http2_faults_total",
+				Help: "Total number of HTTP/2 faults injected, by fault type",
+			},
+			[]string{"path", "method", "fault"},
+		),
+		BackendSampledDelay: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_backend_sampled_delay_seconds",
+				Help:    "Delays drawn from endpoint delay distributions in seconds",
+				Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+			},
+			[]string{"path", "method"},
+		),
+		BackendScenarioFailures: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_backend_scenario_failures_total",
+				Help: "Total number of requests failed by endpoint scenarios",
+			},
+			[]string{"path", "method", "scenario", "outcome"},
+		),
+		BackendTruncatedResponses: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_backend_truncated_responses_total",
+				Help: "Total number of response bodies cut short on purpose, by reason",
+			},
+			[]string{"path", "method", "reason"},
+		),
+
+		// TCP backend metrics
+		TCPBackendConnectionsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tcp_backend_connections_total",
+				Help: "Total number of TCP connections handled, by the action applied",
+			},
+			[]string{"listener", "action"},
+		),
+		TCPBackendActiveConnections: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tcp_backend_active_connections",
+				Help: "Number of TCP connections currently open",
+			},
+			[]string{"listener"},
+		),
+		TCPBackendConnectionDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "tcp_backend_connection_duration_seconds",
+				Help:    "How long TCP connections stayed open in seconds",
+				Buckets: []float64{.001, .01, .1, 1, 5, 10, 30, 60, 300},
+			},
+			[]string{"listener"},
+		),
+		TCPBackendBytesTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tcp_backend_bytes_total",
+				Help: "Total number of bytes transferred on TCP listeners",
+			},
+			[]string{"listener", "direction"},
+		),
+		TCPBackendIdleDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "tcp_backend_idle_duration_seconds",
+				Help:    "Duration TCP connections were kept idle in seconds",
+				Buckets: []float64{1, 5, 10, 30, 60, 120, 300},
+			},
+			[]string{"listener"},
+		),
+
+		// DNS backend metrics
+		DNSBackendQueriesTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dns_backend_queries_total",
+				Help: "Total number of DNS queries, by the response code sent",
+			},
+			[]string{"listener", "protocol", "qtype", "rcode"},
+		),
+		DNSBackendFaultsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dns_backend_injected_faults_total",
+				Help: "Total number of DNS queries with an injected fault",
+			},
+			[]string{"listener", "fault"},
+		),
+
+		// Network shaping metrics
+		ShapingStallsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "network_shaping_stalls_total",
+				Help: "Total number of reads and writes stalled by network shaping",
+			},
+			[]string{"side", "name"},
+		),
 	}
 }
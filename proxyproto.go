@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds the wait for the PROXY protocol header
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts every PROXY protocol version 2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyHeader is the PROXY protocol header a load balancer sent before the
+// connection data
+type ProxyHeader struct {
+	Version     int               `json:"version"`
+	Command     string            `json:"command"`               // PROXY, or LOCAL for health checks of the proxy itself
+	Protocol    string            `json:"protocol"`              // TCP4, TCP6, UDP4, UDP6 or UNKNOWN
+	Source      string            `json:"source,omitempty"`      // Original client address
+	Destination string            `json:"destination,omitempty"` // Address the client connected to
+	TLVs        map[string]string `json:"tlvs,omitempty"`        // Version 2 extensions by type
+	PeerAddr    string            `json:"peer_addr"`             // Address of the proxy
+}
+
+// proxyListener reads a PROXY protocol header at the start of every accepted
+// connection. The source it carries becomes the remote address.
+type proxyListener struct {
+	net.Listener
+	logger *Logger
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// The header is read on first use so a slow client cannot hold up Accept
+	return &proxyConn{Conn: conn, logger: l.logger}, nil
+}
+
+// proxyConn is a connection starting with a PROXY protocol header
+type proxyConn struct {
+	net.Conn
+	logger *Logger
+
+	once   sync.Once
+	reader *bufio.Reader
+	header *ProxyHeader
+	err    error
+}
+
+// NetConn returns the connection from the proxy
+func (c *proxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// readHeader parses the header once. Connections without a valid header fail.
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.reader = bufio.NewReader(c.Conn)
+		c.header, c.err = parseProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.logger.Warn("Invalid PROXY protocol header from %s: %v", c.Conn.RemoteAddr(), c.err)
+			return
+		}
+		c.header.PeerAddr = c.Conn.RemoteAddr().String()
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the client address from the PROXY header, or the
+// address of the proxy for LOCAL and UNKNOWN connections
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Source != "" {
+		if addr, err := net.ResolveTCPAddr("tcp", c.header.Source); err == nil {
+			return addr
+		}
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyHeader returns the parsed header, or nil when it was invalid
+func (c *proxyConn) ProxyHeader() *ProxyHeader {
+	c.readHeader()
+	return c.header
+}
+
+// parseProxyHeader reads a version 1 or 2 PROXY protocol header from r
+func parseProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	start, err := r.Peek(6)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if string(start) == "PROXY " {
+		return parseProxyV1(r)
+	}
+	if start, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(start, proxyV2Signature) {
+		return parseProxyV2(r)
+	}
+	return nil, errors.New("connection does not start with a PROXY protocol header")
+}
+
+// parseProxyV1 parses the text header, e.g. "PROXY TCP4 10.0.0.1 10.0.0.2 5000 80\r\n"
+func parseProxyV1(r *bufio.Reader) (*ProxyHeader, error) {
+	// The longest version 1 header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("version 1 header is not terminated by CRLF")
+	}
+
+	fields := strings.Split(text, " ")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("malformed header %q", text)
+	}
+	header := &ProxyHeader{Version: 1, Command: "PROXY", Protocol: fields[1]}
+	switch {
+	case header.Protocol == "UNKNOWN":
+		return header, nil
+	case header.Protocol != "TCP4" && header.Protocol != "TCP6":
+		return nil, fmt.Errorf("unsupported protocol %q", header.Protocol)
+	case len(fields) != 6:
+		return nil, fmt.Errorf("malformed header %q", text)
+	}
+	for _, port := range fields[4:] {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port %q", port)
+		}
+	}
+	if net.ParseIP(fields[2]) == nil || net.ParseIP(fields[3]) == nil {
+		return nil, fmt.Errorf("invalid address in %q", text)
+	}
+	header.Source = net.JoinHostPort(fields[2], fields[4])
+	header.Destination = net.JoinHostPort(fields[3], fields[5])
+	return header, nil
+}
+
+// parseProxyV2 parses the binary header
+func parseProxyV2(r *bufio.Reader) (*ProxyHeader, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", fixed[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("reading addresses: %w", err)
+	}
+
+	header := &ProxyHeader{Version: 2, Command: "PROXY", Protocol: "UNKNOWN"}
+	switch fixed[12] & 0x0f {
+	case 0x0:
+		header.Command = "LOCAL"
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported command %d", fixed[12]&0x0f)
+	}
+
+	// Address family in the high nibble, transport in the low one
+	var addrLen int
+	switch fixed[13] {
+	case 0x11:
+		header.Protocol, addrLen = "TCP4", 12
+	case 0x12:
+		header.Protocol, addrLen = "UDP4", 12
+	case 0x21:
+		header.Protocol, addrLen = "TCP6", 36
+	case 0x22:
+		header.Protocol, addrLen = "UDP6", 36
+	case 0x31, 0x32:
+		// Unix sockets carry 216 bytes of paths
+		header.Protocol, addrLen = "UNIX", 216
+	}
+	if len(payload) < addrLen {
+		return nil, fmt.Errorf("address block of %d bytes is too short", len(payload))
+	}
+	if header.Command == "PROXY" && addrLen > 0 && addrLen < 216 {
+		ipLen := (addrLen - 4) / 2
+		src := net.IP(payload[:ipLen])
+		dst := net.IP(payload[ipLen : 2*ipLen])
+		ports := payload[2*ipLen : addrLen]
+		header.Source = net.JoinHostPort(src.String(), strconv.Itoa(int(binary.BigEndian.Uint16(ports[0:2]))))
+		header.Destination = net.JoinHostPort(dst.String(), strconv.Itoa(int(binary.BigEndian.Uint16(ports[2:4]))))
+	}
+
+	tlvs, err := parseProxyTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	header.TLVs = tlvs
+	return header, nil
+}
+
+// proxyTLVNames names the registered version 2 extension types
+var proxyTLVNames = map[byte]string{
+	0x01: "alpn",
+	0x02: "authority",
+	0x03: "crc32c",
+	0x04: "noop",
+	0x05: "unique_id",
+	0x20: "ssl",
+	0x30: "netns",
+}
+
+// parseProxyTLVs decodes version 2 extensions. Printable values are kept as
+// text, others are hex encoded.
+func parseProxyTLVs(data []byte) (map[string]string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	tlvs := make(map[string]string)
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, errors.New("truncated TLV")
+		}
+		kind, length := data[0], int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+length {
+			return nil, fmt.Errorf("TLV 0x%02x of %d bytes is truncated", kind, length)
+		}
+		value := data[3 : 3+length]
+		data = data[3+length:]
+		if kind == 0x04 {
+			continue
+		}
+
+		name, ok := proxyTLVNames[kind]
+		if !ok {
+			name = fmt.Sprintf("0x%02x", kind)
+		}
+		if isPrintable(value) {
+			tlvs[name] = string(value)
+		} else {
+			tlvs[name] = fmt.Sprintf("%x", value)
+		}
+	}
+	return tlvs, nil
+}
+
+// isPrintable reports whether data is printable ASCII
+func isPrintable(data []byte) bool {
+	for _, b := range data {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
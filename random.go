@@ -0,0 +1,70 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// randomSeed is the seed of the run, from which every consumer of randomness
+// derives its own generator
+var randomSeed uint64
+
+// seedRandom sets the seed of the run, picking one when seed is zero, and
+// returns it so it can be logged and reused
+func seedRandom(seed uint64) uint64 {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	randomSeed = seed
+	return seed
+}
+
+// newRand returns a generator for one consumer of randomness, such as an
+// endpoint or a listener. Each stream name gets its own sequence, so the
+// decisions made for one endpoint do not depend on the traffic of the others.
+func newRand(stream string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(stream))
+	return rand.New(rand.NewPCG(randomSeed, h.Sum64()))
+}
+
+// lockedRand is a generator that can be shared by concurrent requests
+type lockedRand struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// newLockedRand returns a shareable generator for stream, see newRand
+func newLockedRand(stream string) *lockedRand {
+	return &lockedRand{rng: newRand(stream)}
+}
+
+// Percent returns a number in [0, 100) to compare with a percentage
+func (r *lockedRand) Percent() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Float64() * 100
+}
+
+// Uint64 returns a random number
+func (r *lockedRand) Uint64() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Uint64()
+}
+
+// IntN returns a number in [0, n)
+func (r *lockedRand) IntN(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.IntN(n)
+}
+
+// Duration returns a duration in [0, d)
+func (r *lockedRand) Duration(d time.Duration) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.rng.Int64N(int64(d)))
+}
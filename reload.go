@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Reloader re-reads the configuration file and applies it to the running components
+type Reloader struct {
+	filename string
+	logger   *Logger
+	client   *Client
+	backend  *Backend
+
+	mu      sync.Mutex
+	current *Config
+	hash    [sha256.Size]byte
+}
+
+// NewReloader creates a reloader for the components started from config
+func NewReloader(filename string, config *Config, client *Client, backend *Backend, logger *Logger) *Reloader {
+	r := &Reloader{
+		filename: filename,
+		logger:   logger,
+		client:   client,
+		backend:  backend,
+		current:  config,
+	}
+	if data, err := os.ReadFile(filename); err == nil {
+		r.hash = sha256.Sum256(data)
+	}
+	return r
+}
+
+// Watch polls the configuration file and reloads it when its content changes.
+// Polling the content (rather than inotify events on the file) also catches the
+// symlink swap Kubernetes performs when a mounted ConfigMap is updated.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	r.logger.Info("Watching %s for changes every %v", r.filename, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(r.filename)
+			if err != nil {
+				r.logger.Debug("Config watch: %v", err)
+				continue
+			}
+			r.mu.Lock()
+			changed := sha256.Sum256(data) != r.hash
+			r.mu.Unlock()
+			if changed {
+				r.Reload("file change")
+			}
+		}
+	}
+}
+
+// Reload loads and validates the configuration file and swaps the client
+// endpoints and backend routes in place. An invalid file leaves the running
+// configuration untouched.
+func (r *Reloader) Reload(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logger.Info("Reloading configuration from %s (%s)...", r.filename, reason)
+
+	data, err := os.ReadFile(r.filename)
+	if err != nil {
+		r.logger.Error("Reload failed, keeping current configuration: %v", err)
+		return
+	}
+	r.hash = sha256.Sum256(data)
+
+	config, err := LoadConfig(r.filename)
+	if err != nil {
+		r.logger.Error("Reload failed, keeping current configuration: %v", err)
+		return
+	}
+
+	if config.Type != r.current.Type {
+		r.logger.Error("Reload failed, keeping current configuration: type cannot change from '%s' to '%s' without a restart",
+			r.current.Type, config.Type)
+		return
+	}
+	if config.Seed != r.current.Seed {
+		r.logger.Warn("Reload: seed changes require a restart")
+	}
+
+	if r.client != nil {
+		if config.Client.Timeout != r.current.Client.Timeout {
+			r.logger.Warn("Reload: client timeout changes require a restart, keeping %v", r.current.Client.Timeout)
+			config.Client.Timeout = r.current.Client.Timeout
+		}
+		if !reflect.DeepEqual(config.Client.Results, r.current.Client.Results) {
+			r.logger.Warn("Reload: client results changes require a restart")
+			config.Client.Results = r.current.Client.Results
+		}
+	}
+
+	// Build the client side first and switch it only once the backend side is
+	// in place, so a failure on either side keeps both running as they were
+	var applyClient func()
+	if r.client != nil {
+		if applyClient, err = r.client.PrepareUpdate(config.Client); err != nil {
+			r.logger.Error("Reload failed, keeping current configuration: %v", err)
+			return
+		}
+	}
+
+	if r.backend != nil {
+		endpoints := config.Backend.Endpoints
+		if err := r.backend.UpdateEndpoints(func([]BackendEndpoint) ([]BackendEndpoint, error) {
+			return endpoints, nil
+		}); err != nil {
+			r.logger.Error("Reload failed, keeping current configuration: backend endpoints: %v", err)
+			return
+		}
+		r.logger.Info("Reload: backend now serving %d endpoints", len(endpoints))
+		r.warnRestartRequired(config.Backend)
+	}
+
+	if applyClient != nil {
+		applyClient()
+		r.logger.Info("Reload: client now running %d endpoints", len(config.Client.Endpoints))
+	}
+
+	r.current = config
+}
+
+// warnRestartRequired logs backend settings that only take effect after a restart
+func (r *Reloader) warnRestartRequired(next *BackendConfig) {
+	current := r.current.Backend
+	if next.Port != current.Port {
+		r.logger.Warn("Reload: backend port changes require a restart")
+	}
+	if !reflect.DeepEqual(next.TLS, current.TLS) {
+		r.logger.Warn("Reload: backend tls changes require a restart")
+	}
+	if !reflect.DeepEqual(next.TCPListeners, current.TCPListeners) {
+		r.logger.Warn("Reload: backend tcp_listeners changes require a restart")
+	}
+	if !reflect.DeepEqual(next.HTTP2, current.HTTP2) {
+		r.logger.Warn("Reload: backend http2 changes require a restart")
+	}
+	if !reflect.DeepEqual(next.DNSListeners, current.DNSListeners) {
+		r.logger.Warn("Reload: backend dns_listeners changes require a restart")
+	}
+	if !reflect.DeepEqual(next.Shaping, current.Shaping) {
+		r.logger.Warn("Reload: backend shaping changes require a restart")
+	}
+	if !reflect.DeepEqual(next.Default, current.Default) {
+		r.logger.Warn("Reload: backend default changes require a restart")
+	}
+	if next.ProxyProtocol != current.ProxyProtocol {
+		r.logger.Warn("Reload: backend proxy_protocol changes require a restart")
+	}
+	if !reflect.DeepEqual(next.Admin, current.Admin) {
+		r.logger.Warn("Reload: backend admin changes require a restart")
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Timing phases tracked for the end-of-run report
+const (
+	phaseTotal = "total"
+	phaseDNS   = "dns"
+	phaseTCP   = "tcp"
+	phaseTLS   = "tls"
+	phaseTTFB  = "ttfb"
+)
+
+var reportPhases = []string{phaseTotal, phaseDNS, phaseTCP, phaseTLS, phaseTTFB}
+
+// runStats accumulates per-endpoint results for the end-of-run report
+type runStats struct {
+	mu        sync.Mutex
+	endpoints []*endpointStats
+	frozen    bool // Set once the report is taken; later results are ignored
+}
+
+// endpointStats holds the results of a single endpoint. It survives reloads as
+// long as the endpoint keeps its name.
+type endpointStats struct {
+	stats     *runStats
+	name      string
+	kind      string
+	targetRPS float64
+	started   time.Time
+
+	requests  int64
+	succeeded int64
+	failed    int64
+	retries   int64
+	errors    map[string]int64
+	phases    map[string]*latencyHistogram
+}
+
+// RunReport summarizes a client run
+type RunReport struct {
+	Duration  time.Duration    `json:"-"`
+	Endpoints []EndpointReport `json:"endpoints"`
+}
+
+// EndpointReport summarizes the results of one endpoint
+type EndpointReport struct {
+	Name        string           `json:"name"`
+	Type        string           `json:"type"`
+	Requests    int64            `json:"requests"`
+	Succeeded   int64            `json:"succeeded"`
+	Failed      int64            `json:"failed"`
+	Retries     int64            `json:"retries"`
+	Errors      map[string]int64 `json:"errors"` // Failed attempts by error_type
+	TargetRPS   float64          `json:"target_rps"`
+	AchievedRPS float64          `json:"achieved_rps"`
+	Latency     []LatencySummary `json:"latency"`
+}
+
+// LatencySummary holds the percentiles of one timing phase
+type LatencySummary struct {
+	Phase string
+	Count int64
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+	Max   time.Duration
+}
+
+func newRunStats() *runStats {
+	return &runStats{}
+}
+
+// endpoint returns the stats for an endpoint, creating them on first use
+func (s *runStats) endpoint(endpoint EndpointConfig, targetRPS float64) *endpointStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.endpoints {
+		if e.name == endpoint.Name {
+			e.kind = endpoint.Type
+			e.targetRPS = targetRPS
+			return e
+		}
+	}
+	e := &endpointStats{
+		stats:     s,
+		name:      endpoint.Name,
+		kind:      endpoint.Type,
+		targetRPS: targetRPS,
+		started:   time.Now(),
+		errors:    make(map[string]int64),
+		phases:    make(map[string]*latencyHistogram),
+	}
+	s.endpoints = append(s.endpoints, e)
+	return e
+}
+
+// recordRequest counts a request once its retries are exhausted or it succeeded
+func (e *endpointStats) recordRequest(ok bool, retries int) {
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+	if e.stats.frozen {
+		return
+	}
+	e.requests++
+	e.retries += int64(retries)
+	if ok {
+		e.succeeded++
+	} else {
+		e.failed++
+	}
+}
+
+// recordError counts a failed attempt by error type
+func (e *endpointStats) recordError(errorType string) {
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+	if e.stats.frozen {
+		return
+	}
+	e.errors[errorType]++
+}
+
+// observe records the duration of a timing phase
+func (e *endpointStats) observe(phase string, d time.Duration) {
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+	if e.stats.frozen {
+		return
+	}
+	h, ok := e.phases[phase]
+	if !ok {
+		h = &latencyHistogram{}
+		e.phases[phase] = h
+	}
+	h.record(d)
+}
+
+// report stops collecting results and summarizes them
+func (s *runStats) report(started time.Time) *RunReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frozen = true
+
+	now := time.Now()
+	report := &RunReport{Duration: now.Sub(started)}
+	for _, e := range s.endpoints {
+		r := EndpointReport{
+			Name:      e.name,
+			Type:      e.kind,
+			Requests:  e.requests,
+			Succeeded: e.succeeded,
+			Failed:    e.failed,
+			Retries:   e.retries,
+			Errors:    make(map[string]int64, len(e.errors)),
+			TargetRPS: e.targetRPS,
+		}
+		for errorType, n := range e.errors {
+			r.Errors[errorType] = n
+		}
+		if elapsed := now.Sub(e.started).Seconds(); elapsed > 0 {
+			r.AchievedRPS = float64(e.requests) / elapsed
+		}
+		for _, phase := range reportPhases {
+			h, ok := e.phases[phase]
+			if !ok || h.count == 0 {
+				continue
+			}
+			r.Latency = append(r.Latency, LatencySummary{
+				Phase: phase,
+				Count: h.count,
+				P50:   h.percentile(0.50),
+				P90:   h.percentile(0.90),
+				P99:   h.percentile(0.99),
+				P999:  h.percentile(0.999),
+				Max:   h.max,
+			})
+		}
+		report.Endpoints = append(report.Endpoints, r)
+	}
+	return report
+}
+
+// MarshalJSON reports the run duration in seconds
+func (r *RunReport) MarshalJSON() ([]byte, error) {
+	type plain RunReport
+	return json.Marshal(struct {
+		DurationSeconds float64 `json:"duration_seconds"`
+		*plain
+	}{r.Duration.Seconds(), (*plain)(r)})
+}
+
+// MarshalJSON reports the percentiles in milliseconds
+func (l LatencySummary) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return json.Marshal(struct {
+		Phase string  `json:"phase"`
+		Count int64   `json:"count"`
+		P50   float64 `json:"p50_ms"`
+		P90   float64 `json:"p90_ms"`
+		P99   float64 `json:"p99_ms"`
+		P999  float64 `json:"p99_9_ms"`
+		Max   float64 `json:"max_ms"`
+	}{l.Phase, l.Count, ms(l.P50), ms(l.P90), ms(l.P99), ms(l.P999), ms(l.Max)})
+}
+
+// writeReport writes the report in the configured format to stdout or a file
+func writeReport(report *RunReport, cfg *ReportConfig) error {
+	var w io.Writer = os.Stdout
+	if cfg.Output != "" && cfg.Output != "-" {
+		f, err := os.Create(cfg.Output)
+		if err != nil {
+			return fmt.Errorf("failed to create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch cfg.Format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "markdown":
+		return writeReportMarkdown(w, report)
+	default:
+		return writeReportTable(w, report)
+	}
+}
+
+// writeReportTable writes the report as aligned plain-text tables
+func writeReportTable(w io.Writer, report *RunReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\n=== Run Summary (%v) ===\n\n", report.Duration.Round(time.Millisecond))
+	fmt.Fprintln(tw, "ENDPOINT\tTYPE\tREQUESTS\tOK\tFAILED\tRETRIES\tRPS\tTARGET RPS\tERRORS")
+	for _, e := range report.Endpoints {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%.2f\t%.2f\t%s\n",
+			e.Name, e.Type, e.Requests, e.Succeeded, e.Failed, e.Retries, e.AchievedRPS, e.TargetRPS, formatErrors(e.Errors))
+	}
+
+	for _, e := range report.Endpoints {
+		if len(e.Latency) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "\nLatency [%s]\tCOUNT\tP50\tP90\tP99\tP99.9\tMAX\n", e.Name)
+		for _, l := range e.Latency {
+			fmt.Fprintf(tw, "  %s\t%d\t%v\t%v\t%v\t%v\t%v\n", l.Phase, l.Count,
+				formatLatency(l.P50), formatLatency(l.P90), formatLatency(l.P99), formatLatency(l.P999), formatLatency(l.Max))
+		}
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
+
+// writeReportMarkdown writes the report as Markdown tables
+func writeReportMarkdown(w io.Writer, report *RunReport) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Run Summary (%v)\n\n", report.Duration.Round(time.Millisecond))
+	b.WriteString("| Endpoint | Type | Requests | OK | Failed | Retries | RPS | Target RPS | Errors |\n")
+	b.WriteString("|---|---|---:|---:|---:|---:|---:|---:|---|\n")
+	for _, e := range report.Endpoints {
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %d | %d | %.2f | %.2f | %s |\n",
+			e.Name, e.Type, e.Requests, e.Succeeded, e.Failed, e.Retries, e.AchievedRPS, e.TargetRPS, formatErrors(e.Errors))
+	}
+
+	for _, e := range report.Endpoints {
+		if len(e.Latency) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### Latency: %s\n\n", e.Name)
+		b.WriteString("| Phase | Count | p50 | p90 | p99 | p99.9 | max |\n")
+		b.WriteString("|---|---:|---:|---:|---:|---:|---:|\n")
+		for _, l := range e.Latency {
+			fmt.Fprintf(&b, "| %s | %d | %v | %v | %v | %v | %v |\n", l.Phase, l.Count,
+				formatLatency(l.P50), formatLatency(l.P90), formatLatency(l.P99), formatLatency(l.P999), formatLatency(l.Max))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatErrors formats error counts as "type=n" pairs sorted by type
+func formatErrors(errors map[string]int64) string {
+	if len(errors) == 0 {
+		return "-"
+	}
+	types := make([]string, 0, len(errors))
+	for errorType := range errors {
+		types = append(types, errorType)
+	}
+	slices.Sort(types)
+
+	parts := make([]string, 0, len(types))
+	for _, errorType := range types {
+		parts = append(parts, fmt.Sprintf("%s=%d", errorType, errors[errorType]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatLatency rounds a duration to a readable precision
+func formatLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
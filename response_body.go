@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setStreamHeaders sets the headers of a streamed body of size bytes before
+// the status is written. A content_length smaller than a generated or
+// templated body is not advertised, so the body is not cut at it.
+func (b *Backend) setStreamHeaders(w http.ResponseWriter, r *http.Request, cfg *BodyStreamConfig, size int64) {
+	switch {
+	case cfg.ContentLength <= 0:
+	case int64(cfg.ContentLength) < size:
+		b.logger.Warn("Not advertising content_length %d for %s %s: the body has %d bytes", cfg.ContentLength, r.Method, r.URL.Path, size)
+	default:
+		w.Header().Set("Content-Length", strconv.Itoa(cfg.ContentLength))
+	}
+}
+
+// streamBody writes a body of size bytes as configured by the endpoint stream
+// settings and returns the number of bytes sent. Bodies that are cut short end
+// with the connection closed, or the stream reset on HTTP/2.
+func (b *Backend) streamBody(w http.ResponseWriter, r *http.Request, endpoint BackendEndpoint, body io.Reader, size int64) int64 {
+	cfg := endpoint.Stream
+	rc := http.NewResponseController(w)
+
+	limit := size
+	if cfg.StallAfterPercent > 0 {
+		limit = int64(float64(size) * cfg.StallAfterPercent / 100)
+	} else if cfg.AbortAfterPercent > 0 {
+		limit = int64(float64(size) * cfg.AbortAfterPercent / 100)
+	}
+
+	chunkSize := int64(cfg.ChunkSize)
+	if chunkSize == 0 {
+		chunkSize = size
+		if cfg.BytesPerSecond > 0 {
+			// Ten writes per second keep the rate smooth
+			chunkSize = int64(max(1, cfg.BytesPerSecond/10))
+		}
+	}
+
+	// Send the headers before the first chunk is due
+	rc.Flush()
+
+	start := time.Now()
+	var sent int64
+	for sent < limit {
+		n, err := io.CopyN(w, body, min(chunkSize, limit-sent))
+		sent += n
+		if err != nil {
+			return sent
+		}
+		rc.Flush()
+
+		pause := cfg.ChunkDelay
+		if cfg.BytesPerSecond > 0 {
+			due := time.Duration(float64(sent) / float64(cfg.BytesPerSecond) * float64(time.Second))
+			pause = max(pause, due-time.Since(start))
+		}
+		if pause > 0 && sent < limit {
+			select {
+			case <-r.Context().Done():
+				return sent
+			case <-time.After(pause):
+			}
+		}
+	}
+
+	switch {
+	case cfg.StallAfterPercent > 0:
+		b.logger.Warn("Stalling %s %s after %d of %d body bytes", r.Method, r.URL.Path, sent, size)
+		b.metrics.BackendTruncatedResponses.WithLabelValues(r.URL.Path, r.Method, "stall").Inc()
+		if cfg.StallDuration > 0 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(cfg.StallDuration):
+			}
+		} else {
+			<-r.Context().Done()
+		}
+		b.resetStream(r, endpoint)
+	case cfg.AbortAfterPercent > 0:
+		b.logger.Warn("Aborting %s %s after %d of %d body bytes", r.Method, r.URL.Path, sent, size)
+		b.metrics.BackendTruncatedResponses.WithLabelValues(r.URL.Path, r.Method, "abort").Inc()
+		b.resetStream(r, endpoint)
+	case int64(cfg.ContentLength) > sent:
+		b.logger.Warn("Closing %s %s after %d of %d advertised body bytes", r.Method, r.URL.Path, sent, cfg.ContentLength)
+		b.metrics.BackendTruncatedResponses.WithLabelValues(r.URL.Path, r.Method, "short_content_length").Inc()
+		rc.Flush()
+		b.resetStream(r, endpoint)
+	}
+	return sent
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStreamContentLengthOfStaticBody(t *testing.T) {
+	ep := BackendEndpoint{Path: "/static", Body: "0123456789", Stream: &BodyStreamConfig{ContentLength: 5}}
+	if err := validateBackendEndpoint(&ep); err == nil {
+		t.Errorf("content_length smaller than a static body was accepted")
+	}
+}
+
+func TestStreamContentLengthOfDynamicBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint BackendEndpoint
+		wantBody string
+	}{
+		{
+			name:     "generate",
+			endpoint: BackendEndpoint{Path: "/generated", Generate: &GeneratedBodyConfig{Size: 10, Pattern: "x"}},
+			wantBody: strings.Repeat("x", 10),
+		},
+		{
+			name:     "template",
+			endpoint: BackendEndpoint{Path: "/templated", Template: true, Body: "{{.Method}} {{.Path}}"},
+			wantBody: "GET /templated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Larger than content_length, which is only known per response
+			tt.endpoint.Stream = &BodyStreamConfig{ContentLength: 5}
+			rt := newTestRouter(t, tt.endpoint)
+
+			w := serve(rt, http.MethodGet, tt.endpoint.Path)
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body %q, want %q", got, tt.wantBody)
+			}
+			if got := w.Header().Get("Content-Length"); got == "5" {
+				t.Errorf("content_length advertised for a longer body")
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// requestResult is one line of the per-request result stream
+type requestResult struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Endpoint      string    `json:"endpoint"`
+	Type          string    `json:"type"`
+	Method        string    `json:"method"`
+	URL           string    `json:"url"`
+	Attempt       int       `json:"attempt"`
+	Status        int       `json:"status,omitempty"`
+	Protocol      string    `json:"protocol,omitempty"` // Negotiated protocol of http endpoints, e.g. HTTP/2.0
+	ErrorClass    string    `json:"error_class,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+	DNSMs         float64   `json:"dns_ms,omitempty"`
+	TCPMs         float64   `json:"tcp_ms,omitempty"`
+	TLSMs         float64   `json:"tls_ms,omitempty"`
+	TTFBMs        float64   `json:"ttfb_ms,omitempty"`
+	TotalMs       float64   `json:"total_ms"`
+	ResolvedAddrs []string  `json:"resolved_addrs,omitempty"` // Lookup results, when a lookup was made
+	RemoteAddr    string    `json:"remote_addr,omitempty"`
+	LocalPort     int       `json:"local_port,omitempty"`
+	Reused        bool      `json:"reused"`
+	ConnAgeMs     float64   `json:"conn_age_ms,omitempty"` // Reused connections only
+	IdleMs        float64   `json:"idle_ms,omitempty"`     // Time the reused connection spent in the pool
+}
+
+// setConn records the addresses of the connection a request used
+func (r *requestResult) setConn(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	r.RemoteAddr = conn.RemoteAddr().String()
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		r.LocalPort = addr.Port
+	}
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// resultWriter appends request results as JSON lines to a file, rotating it
+// when it grows beyond the configured size
+type resultWriter struct {
+	config *ResultsConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// newResultWriter opens (or creates) the results file for appending
+func newResultWriter(config *ResultsConfig) (*resultWriter, error) {
+	w := &resultWriter{config: config}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the results file and picks up its current size
+func (w *resultWriter) open() error {
+	file, err := os.OpenFile(w.config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open results file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat results file: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// write appends a result, rotating the file first if the line would exceed the size limit
+func (w *resultWriter) write(result *requestResult) error {
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil // Attempts finishing during shutdown
+	}
+	if w.file == nil {
+		// A previous rotation failed to reopen the file
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	maxSize := int64(w.config.MaxSizeMB) << 20
+	if w.size > 0 && w.size+int64(len(line)) > maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+// rotate renames file to file.1 (shifting older backups up and dropping the
+// oldest) and starts a new file
+func (w *resultWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	name := w.config.File
+	os.Remove(fmt.Sprintf("%s.%d", name, w.config.MaxBackups))
+	for i := w.config.MaxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", name, i), fmt.Sprintf("%s.%d", name, i+1))
+	}
+	// Validation keeps at least one backup
+	if err := os.Rename(name, name+".1"); err != nil {
+		return fmt.Errorf("failed to rotate results file: %w", err)
+	}
+	return w.open()
+}
+
+// Close closes the results file
+func (w *resultWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxPeekBodySize limits how much of a request body is read to match JSON rules
+// or render templates
+const maxPeekBodySize = 1 << 20
+
+// router picks the endpoint answering each request
+type router struct {
+	metrics  http.Handler // Serves /metrics when set
+	routes   []*route     // By descending priority, then most specific path first, then configuration order
+	fallback http.Handler // Requests no route matches
+}
+
+// route is an endpoint with its compiled matching rules
+type route struct {
+	endpoint BackendEndpoint
+	mux      *http.ServeMux // Holds the path pattern alone, to match it and set path values
+	methods  []string
+	match    *requestMatcher // nil without match conditions
+}
+
+// ServeHTTP dispatches r to the first route that matches it. Requests whose
+// path matches but whose method does not get a 405.
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rt.metrics != nil && r.URL.Path == "/metrics" {
+		rt.metrics.ServeHTTP(w, r)
+		return
+	}
+
+	var allowed []string
+	var body []byte
+	bodyRead := false
+	for _, route := range rt.routes {
+		if _, pattern := route.mux.Handler(r); pattern == "" {
+			continue
+		}
+		if !slices.Contains(route.methods, r.Method) && !slices.Contains(route.methods, "*") {
+			allowed = append(allowed, route.methods...)
+			continue
+		}
+		if route.match != nil {
+			if len(route.match.json) > 0 && !bodyRead {
+				body = peekBody(r)
+				bodyRead = true
+			}
+			if !route.match.matches(r, body) {
+				continue
+			}
+		}
+		route.mux.ServeHTTP(w, r)
+		return
+	}
+
+	if len(allowed) > 0 {
+		slices.Sort(allowed)
+		w.Header().Set("Allow", strings.Join(slices.Compact(allowed), ", "))
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rt.fallback.ServeHTTP(w, r)
+}
+
+// peekBody reads the start of the request body and puts it back for the handler
+func peekBody(r *http.Request) []byte {
+	body, _ := io.ReadAll(io.LimitReader(r.Body, maxPeekBodySize))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	return body
+}
+
+// buildRouter creates a router for the metrics endpoint and the given endpoints
+func (b *Backend) buildRouter(endpoints []BackendEndpoint) *router {
+	rt := &router{metrics: b.metricsHandler, fallback: http.NotFoundHandler()}
+	if b.config.Default != nil {
+		rt.fallback = b.createHandler(*b.config.Default)
+	}
+
+	for _, endpoint := range endpoints {
+		route, err := b.newRoute(endpoint)
+		if err != nil {
+			// Endpoints are validated before they get here
+			b.logger.Error("Skipping endpoint %s: %v", endpoint.Path, err)
+			continue
+		}
+		rt.routes = append(rt.routes, route)
+	}
+	rt.routes = orderBySpecificity(rt.routes)
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return rt.routes[i].endpoint.Priority > rt.routes[j].endpoint.Priority
+	})
+	b.pruneState(endpoints)
+	return rt
+}
+
+// orderBySpecificity moves every route ahead of the routes whose paths match
+// all of its paths and more, as http.ServeMux prefers the most specific
+// pattern. Other routes keep their configuration order.
+func orderBySpecificity(routes []*route) []*route {
+	ordered := make([]*route, 0, len(routes))
+	for _, r := range routes {
+		i := slices.IndexFunc(ordered, func(placed *route) bool {
+			return moreSpecific(r.endpoint.Path, placed.endpoint.Path)
+		})
+		if i < 0 {
+			i = len(ordered)
+		}
+		ordered = slices.Insert(ordered, i, r)
+	}
+	return ordered
+}
+
+// moreSpecific reports whether path pattern a matches a strict subset of the
+// paths b matches
+func moreSpecific(a, b string) bool {
+	return patternCovers(b, a) && !patternCovers(a, b)
+}
+
+// patternHandler marks the handler of a pattern in patternCovers
+type patternHandler struct{}
+
+func (*patternHandler) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// patternCovers reports whether pattern matches every path other matches, by
+// matching the most general path of other
+func patternCovers(pattern, other string) bool {
+	handler := &patternHandler{}
+	mux := http.NewServeMux()
+	mux.Handle(pattern, handler)
+	r := &http.Request{Method: http.MethodGet, URL: &url.URL{Path: generalPath(other)}}
+	// Redirects, e.g. from /a to /a/, do not count
+	matched, _ := mux.Handler(r)
+	return matched == http.Handler(handler)
+}
+
+// generalPath returns a path that pattern matches with none of its literal
+// segments taking the place of a wildcard
+func generalPath(pattern string) string {
+	const any = "~any~"
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		switch {
+		case i == 0:
+		case segment == "{$}":
+			segments[i] = ""
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "...}"):
+			segments[i] = any + "/" + any
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			segments[i] = any
+		case segment == "" && i == len(segments)-1:
+			// A trailing slash matches any rest of the path
+			segments[i] = any + "/" + any
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// newRoute compiles the matching rules of an endpoint and creates its handler
+func (b *Backend) newRoute(endpoint BackendEndpoint) (*route, error) {
+	route := &route{
+		endpoint: endpoint,
+		mux:      http.NewServeMux(),
+		methods:  endpoint.allowedMethods(),
+	}
+	if endpoint.Match != nil {
+		match, err := compileRequestMatch(endpoint.Match)
+		if err != nil {
+			return nil, err
+		}
+		route.match = match
+	}
+
+	b.logger.Info("Registering endpoint: %s %s -> Status %d",
+		strings.Join(route.methods, ","), endpoint.Path, endpoint.StatusCode)
+	route.mux.Handle(endpoint.Path, b.createHandler(endpoint))
+	return route, nil
+}
+
+// checkPathPattern reports whether path is a valid http.ServeMux pattern
+// without method or host
+func checkPathPattern(path string) (err error) {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path must start with /, got: %s", path)
+	}
+	// ServeMux panics on invalid patterns
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid path pattern: %v", r)
+		}
+	}()
+	http.NewServeMux().Handle(path, http.NotFoundHandler())
+	return nil
+}
+
+// requestMatcher is the compiled form of a RequestMatch
+type requestMatcher struct {
+	config  *RequestMatch
+	query   []compiledMatchRule
+	headers []compiledMatchRule
+	json    []compiledJSONRule
+}
+
+type compiledMatchRule struct {
+	rule  MatchRule
+	regex *regexp.Regexp
+}
+
+// compileRequestMatch checks and compiles the conditions of a RequestMatch
+func compileRequestMatch(cfg *RequestMatch) (*requestMatcher, error) {
+	m := &requestMatcher{config: cfg}
+
+	compileRules := func(kind string, rules []MatchRule) ([]compiledMatchRule, error) {
+		var compiled []compiledMatchRule
+		for i, rule := range rules {
+			if rule.Name == "" {
+				return nil, fmt.Errorf("%s rule %d: name is required", kind, i)
+			}
+			if rule.Absent && (rule.Value != "" || rule.Regex != "") {
+				return nil, fmt.Errorf("%s rule %d: absent cannot be combined with value or regex", kind, i)
+			}
+			c := compiledMatchRule{rule: rule}
+			if rule.Regex != "" {
+				re, err := regexp.Compile(rule.Regex)
+				if err != nil {
+					return nil, fmt.Errorf("%s rule %d: invalid regex: %w", kind, i, err)
+				}
+				c.regex = re
+			}
+			compiled = append(compiled, c)
+		}
+		return compiled, nil
+	}
+
+	var err error
+	if m.query, err = compileRules("query", cfg.Query); err != nil {
+		return nil, err
+	}
+	if m.headers, err = compileRules("header", cfg.Headers); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range cfg.JSON {
+		path, err := parseJSONPath(rule.Path)
+		if err != nil {
+			return nil, fmt.Errorf("json rule %d: %w", i, err)
+		}
+		expected, err := normalizeJSON(rule.Equals)
+		if err != nil {
+			return nil, fmt.Errorf("json rule %d: %w", i, err)
+		}
+		m.json = append(m.json, compiledJSONRule{rule: rule, path: path, expected: expected})
+	}
+	return m, nil
+}
+
+// matches reports whether r, whose body starts with body, meets every condition
+func (m *requestMatcher) matches(r *http.Request, body []byte) bool {
+	if m.config.Host != "" && !matchHost(m.config.Host, r.Host) {
+		return false
+	}
+
+	query := r.URL.Query()
+	for _, rule := range m.query {
+		values, present := query[rule.rule.Name]
+		if !rule.matches(values, present) {
+			return false
+		}
+	}
+	for _, rule := range m.headers {
+		values := r.Header.Values(rule.rule.Name)
+		if !rule.matches(values, len(values) > 0) {
+			return false
+		}
+	}
+
+	if len(m.json) > 0 {
+		var document interface{}
+		if err := json.Unmarshal(body, &document); err != nil {
+			return false
+		}
+		for _, rule := range m.json {
+			actual, ok := lookupJSONPath(document, rule.path)
+			if !ok || !reflect.DeepEqual(actual, rule.expected) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matches checks the values of a header or query parameter against the rule
+func (c compiledMatchRule) matches(values []string, present bool) bool {
+	if c.rule.Absent {
+		return !present
+	}
+	if !present {
+		return false
+	}
+	value := strings.Join(values, ", ")
+	if c.rule.Value != "" && value != c.rule.Value {
+		return false
+	}
+	return c.regex == nil || c.regex.MatchString(value)
+}
+
+// matchHost compares a request host, which may include a port, with a host
+// pattern such as api.example.com or *.example.com
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
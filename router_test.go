@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// testMetrics is shared by the tests, as metrics register globally
+var testMetrics = sync.OnceValue(NewMetrics)
+
+// newTestRouter validates endpoints and builds the router a backend would serve them with
+func newTestRouter(t *testing.T, endpoints ...BackendEndpoint) *router {
+	t.Helper()
+	for i := range endpoints {
+		if err := validateBackendEndpoint(&endpoints[i]); err != nil {
+			t.Fatalf("endpoint %s: %v", endpoints[i].Path, err)
+		}
+	}
+	b := NewBackend(&BackendConfig{Endpoints: endpoints}, NewLogger(LoggingConfig{Level: "error"}), testMetrics())
+	return b.buildRouter(endpoints)
+}
+
+// serve sends a request through rt and returns the response
+func serve(rt *router, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestRouterPrefersSpecificPaths(t *testing.T) {
+	rt := newTestRouter(t,
+		BackendEndpoint{Path: "/", Body: "catch-all"},
+		BackendEndpoint{Path: "/users/", Body: "users"},
+		BackendEndpoint{Path: "/users/{id}", Body: "user"},
+	)
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/users/42", "user"},
+		{"/users/42/orders", "users"},
+		{"/users/", "users"},
+		{"/other", "catch-all"},
+	}
+	for _, tt := range tests {
+		if got := serve(rt, http.MethodGet, tt.target).Body.String(); got != tt.want {
+			t.Errorf("GET %s answered %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestRouterPriorityBeforeSpecificity(t *testing.T) {
+	rt := newTestRouter(t,
+		BackendEndpoint{Path: "/users/{id}", Body: "user"},
+		BackendEndpoint{Path: "/", Body: "catch-all", Priority: 1},
+	)
+	if got := serve(rt, http.MethodGet, "/users/42").Body.String(); got != "catch-all" {
+		t.Errorf("GET /users/42 answered %q, want the higher priority catch-all", got)
+	}
+}
+
+func TestRouterFallsBackToLessSpecificPaths(t *testing.T) {
+	rt := newTestRouter(t,
+		BackendEndpoint{Path: "/", Body: "catch-all"},
+		BackendEndpoint{Path: "/users/{id}", Method: "POST", Body: "created"},
+	)
+	if got := serve(rt, http.MethodPost, "/users/42").Body.String(); got != "created" {
+		t.Errorf("POST /users/42 answered %q, want %q", got, "created")
+	}
+	if got := serve(rt, http.MethodGet, "/users/42").Body.String(); got != "catch-all" {
+		t.Errorf("GET /users/42 answered %q, want %q", got, "catch-all")
+	}
+}
+
+func TestMoreSpecific(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/users/{id}", "/", true},
+		{"/users/{id}", "/users/", true},
+		{"/users/", "/", true},
+		{"/users/{$}", "/users/", true},
+		{"/files/{name...}", "/files/", false}, // Same paths
+		{"/a", "/a/", false},                   // The redirect from /a to /a/ is no match
+		{"/", "/users/{id}", false},
+		{"/users/{id}", "/orders/{id}", false},
+	}
+	for _, tt := range tests {
+		if got := moreSpecific(tt.a, tt.b); got != tt.want {
+			t.Errorf("moreSpecific(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scenario types
+const (
+	scenarioFailFirst = "fail_first"
+	scenarioWarmup    = "warmup"
+	scenarioFlap      = "flap"
+	scenarioSequence  = "sequence"
+	scenarioCircuit   = "circuit"
+)
+
+// maxScenarioKeys bounds the clients a keyed scenario tracks. When it is
+// reached the state of every key is forgotten.
+const maxScenarioKeys = 10000
+
+// scenarioStep is the outcome of a request in a scenario: a normal response,
+// an error status or a connection fault
+type scenarioStep struct {
+	status int    // Status of the error response
+	fault  string // outcomeDrop, outcomeIdle or outcomeReset
+}
+
+// ok reports whether the step is a normal response
+func (s scenarioStep) ok() bool {
+	return s.status == 0 && s.fault == ""
+}
+
+func (s scenarioStep) String() string {
+	switch {
+	case s.fault != "":
+		return s.fault
+	case s.status != 0:
+		return strconv.Itoa(s.status)
+	}
+	return "ok"
+}
+
+// parseScenarioStep parses "ok", a status code, or drop, idle or reset
+func parseScenarioStep(text string) (scenarioStep, error) {
+	switch text = strings.ToLower(strings.TrimSpace(text)); text {
+	case "ok":
+		return scenarioStep{}, nil
+	case outcomeDrop, outcomeIdle, outcomeReset:
+		return scenarioStep{fault: text}, nil
+	}
+	status, err := strconv.Atoi(text)
+	if err != nil || status < 100 || status > 599 {
+		return scenarioStep{}, fmt.Errorf("%q is not ok, a status code, drop, idle or reset", text)
+	}
+	return scenarioStep{status: status}, nil
+}
+
+// scenario tracks where an endpoint is on its failure timeline
+type scenario struct {
+	config  ScenarioConfig
+	started time.Time
+
+	mu   sync.Mutex
+	keys map[string]*scenarioState // By client IP or header value, "" for all requests when unkeyed
+}
+
+// scenarioState is the progress of one key through a counting scenario
+type scenarioState struct {
+	requests uint64    // Requests seen, or since the circuit last closed
+	opened   time.Time // When the circuit opened, zero while it is closed
+}
+
+// newScenario starts the timeline of a validated scenario
+func newScenario(cfg ScenarioConfig) *scenario {
+	return &scenario{config: cfg, started: time.Now(), keys: make(map[string]*scenarioState)}
+}
+
+// next returns the outcome of r, and the reason for logs when it fails
+func (s *scenario) next(r *http.Request) (scenarioStep, string) {
+	cfg := s.config
+	now := time.Now()
+
+	switch cfg.Type {
+	case scenarioWarmup:
+		elapsed := now.Sub(s.started)
+		if elapsed < cfg.Duration {
+			return cfg.failure, fmt.Sprintf("warming up, %v of %v", elapsed.Round(time.Millisecond), cfg.Duration)
+		}
+		return scenarioStep{}, ""
+	case scenarioFlap:
+		position := now.Sub(s.started) % (cfg.Healthy + cfg.Unhealthy)
+		if position >= cfg.Healthy {
+			return cfg.failure, fmt.Sprintf("unhealthy window, %v of %v", (position - cfg.Healthy).Round(time.Millisecond), cfg.Unhealthy)
+		}
+		return scenarioStep{}, ""
+	}
+
+	key := s.key(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.keys[key]
+	if !ok {
+		if len(s.keys) >= maxScenarioKeys {
+			clear(s.keys)
+		}
+		state = &scenarioState{}
+		s.keys[key] = state
+	}
+	state.requests++
+	prefix := ""
+	if cfg.Key != "" {
+		prefix = fmt.Sprintf("%s %q, ", cfg.Key, key)
+	}
+
+	switch cfg.Type {
+	case scenarioFailFirst:
+		if state.requests <= uint64(cfg.Requests) {
+			return cfg.failure, fmt.Sprintf("%srequest %d of the first %d", prefix, state.requests, cfg.Requests)
+		}
+	case scenarioSequence:
+		steps := uint64(len(cfg.steps))
+		if state.requests > steps && !cfg.Repeat {
+			return scenarioStep{}, ""
+		}
+		i := (state.requests - 1) % steps
+		return cfg.steps[i], fmt.Sprintf("%ssequence position %d of %d", prefix, i+1, steps)
+	case scenarioCircuit:
+		if !state.opened.IsZero() && cfg.Duration > 0 && now.Sub(state.opened) >= cfg.Duration {
+			// Close the circuit and start counting again
+			state.opened, state.requests = time.Time{}, 1
+		}
+		if state.opened.IsZero() && state.requests > uint64(cfg.Requests) {
+			state.opened = now
+		}
+		if !state.opened.IsZero() {
+			return cfg.failure, fmt.Sprintf("%scircuit open for %v after %d requests", prefix, now.Sub(state.opened).Round(time.Millisecond), cfg.Requests)
+		}
+	}
+	return scenarioStep{}, ""
+}
+
+// key returns the state key of r
+func (s *scenario) key(r *http.Request) string {
+	switch key := s.config.Key; {
+	case key == "client_ip":
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	case strings.HasPrefix(key, "header:"):
+		return r.Header.Get(strings.TrimPrefix(key, "header:"))
+	}
+	return ""
+}
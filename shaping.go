@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// shaper slows down the connections it wraps as described by a ShapingConfig.
+// Connections wrapped by the same shaper share its aggregate bandwidth.
+type shaper struct {
+	config         *ShapingConfig
+	aggregateRead  *rateLimiter // nil without an aggregate cap
+	aggregateWrite *rateLimiter
+	rng            *lockedRand // Jitter and stall decisions
+	stalls         prometheus.Counter
+}
+
+// newShaper creates a shaper for cfg, or returns nil when cfg is nil. side and
+// name label the stall metric.
+func newShaper(cfg *ShapingConfig, side, name string, metrics *Metrics) *shaper {
+	if cfg == nil {
+		return nil
+	}
+	return &shaper{
+		config:         cfg,
+		aggregateRead:  newRateLimiter(cfg.AggregateBytesPerSecond),
+		aggregateWrite: newRateLimiter(cfg.AggregateBytesPerSecond),
+		rng:            newLockedRand("shaping " + side + " " + name),
+		stalls:         metrics.ShapingStallsTotal.WithLabelValues(side, name),
+	}
+}
+
+// wrap returns conn shaped by s. A nil shaper returns conn unchanged.
+func (s *shaper) wrap(conn net.Conn) net.Conn {
+	if s == nil {
+		return conn
+	}
+	return &shapedConn{
+		Conn:      conn,
+		shaper:    s,
+		readRate:  newRateLimiter(s.config.BytesPerSecond),
+		writeRate: newRateLimiter(s.config.BytesPerSecond),
+	}
+}
+
+// chunkSize limits single reads and writes so rate-limited transfers are
+// spread evenly instead of arriving in bursts
+func (s *shaper) chunkSize() int {
+	rate := s.config.BytesPerSecond
+	if s.config.AggregateBytesPerSecond > 0 && (rate == 0 || s.config.AggregateBytesPerSecond < rate) {
+		rate = s.config.AggregateBytesPerSecond
+	}
+	if rate == 0 {
+		return 32 * 1024
+	}
+	return min(max(1, rate/10), 32*1024)
+}
+
+// delay waits for the latency, jitter and occasional stall of one operation
+func (s *shaper) delay() {
+	d := s.config.Latency
+	if s.config.Jitter > 0 {
+		d += s.rng.Duration(s.config.Jitter)
+	}
+	if s.config.StallPercent > 0 && s.rng.Percent() < s.config.StallPercent {
+		s.stalls.Inc()
+		d += s.config.StallDuration
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// shapeListener wraps the connections accepted by l with a shaper for cfg
+func shapeListener(l net.Listener, cfg *ShapingConfig, name string, metrics *Metrics) net.Listener {
+	if cfg == nil {
+		return l
+	}
+	return &shapedListener{Listener: l, shaper: newShaper(cfg, "backend", name, metrics)}
+}
+
+// shapedListener is a listener whose connections are shaped
+type shapedListener struct {
+	net.Listener
+	shaper *shaper
+}
+
+func (l *shapedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.shaper.wrap(conn), nil
+}
+
+// shapedConn applies latency, stalls and bandwidth caps to reads and writes
+type shapedConn struct {
+	net.Conn
+	shaper    *shaper
+	readRate  *rateLimiter
+	writeRate *rateLimiter
+}
+
+// NetConn returns the connection being shaped
+func (c *shapedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	c.shaper.delay()
+	if size := c.shaper.chunkSize(); len(p) > size {
+		p = p[:size]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		time.Sleep(max(c.readRate.reserve(n), c.shaper.aggregateRead.reserve(n)))
+	}
+	return n, err
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	c.shaper.delay()
+	size := c.shaper.chunkSize()
+	written := 0
+	for written < len(p) {
+		n, err := c.Conn.Write(p[written:min(len(p), written+size)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		time.Sleep(max(c.writeRate.reserve(n), c.shaper.aggregateWrite.reserve(n)))
+	}
+	return written, nil
+}
+
+// rateLimiter paces a byte stream to a fixed rate
+type rateLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int
+	next           time.Time // When the bytes reserved so far have gone through
+}
+
+// newRateLimiter returns a limiter for bytesPerSecond, or nil when it is zero
+func newRateLimiter(bytesPerSecond int) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// reserve accounts for n bytes and returns how long the caller has to wait
+// for them to go through. A nil limiter never waits.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.bytesPerSecond))
+	return l.next.Sub(now)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCPListener represents a raw TCP listener with a scripted connection behaviour
+type TCPListener struct {
+	config  TCPListenerConfig
+	logger  *Logger
+	metrics *Metrics
+	rng     *lockedRand // Drop and idle decisions
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewTCPListener creates a new raw TCP listener
+func NewTCPListener(config TCPListenerConfig, logger *Logger, metrics *Metrics) *TCPListener {
+	return &TCPListener{
+		config:  config,
+		logger:  logger,
+		metrics: metrics,
+		rng:     newLockedRand("tcp " + config.Name),
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// Run accepts connections until the context is cancelled
+func (t *TCPListener) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", t.config.Port))
+	if err != nil {
+		return fmt.Errorf("tcp listener %s: failed to listen on port %d: %w", t.config.Name, t.config.Port, err)
+	}
+	listener = shapeListener(listener, t.config.Shaping, t.config.Name, t.metrics)
+
+	if t.config.TLS != nil {
+		tlsConfig, err := buildServerTLSConfig(t.config.TLS, t.logger)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("tcp listener %s: failed to configure TLS: %w", t.config.Name, err)
+		}
+		tlsConfig.NextProtos = nil
+		listener = newTLSListener(listener, t.config.Name, tlsConfig, t.logger, t.metrics)
+	}
+
+	t.logger.Info("Starting TCP listener [%s] on port %d (behavior: %s)", t.config.Name, t.config.Port, t.config.Behavior)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		t.closeAll()
+	}()
+
+	for {
+		// Delay before accept so that pending connections pile up in the backlog
+		if t.config.AcceptDelay > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(t.config.AcceptDelay):
+			}
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				t.wg.Wait()
+				return ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("tcp listener %s: accept failed: %w", t.config.Name, err)
+		}
+
+		t.track(conn)
+		t.wg.Add(1)
+		go t.handle(ctx, conn)
+	}
+}
+
+// handle applies the fault percentages and the configured behaviour to a connection
+func (t *TCPListener) handle(ctx context.Context, conn net.Conn) {
+	start := time.Now()
+	defer t.wg.Done()
+	defer t.untrack(conn)
+
+	t.metrics.TCPBackendActiveConnections.WithLabelValues(t.config.Name).Inc()
+	defer t.metrics.TCPBackendActiveConnections.WithLabelValues(t.config.Name).Dec()
+
+	action := t.config.Behavior
+	if t.config.DropPercent > 0 || t.config.IdlePercent > 0 {
+		random := t.rng.Percent()
+		if random < t.config.DropPercent {
+			action = "drop"
+		} else if random < t.config.DropPercent+t.config.IdlePercent {
+			action = "idle"
+		}
+	}
+
+	t.logger.Debug("TCP [%s] connection from %s -> %s", t.config.Name, conn.RemoteAddr(), action)
+
+	var received, sent int64
+	switch action {
+	case "drop", "close":
+		// Close right away with a FIN
+	case "idle":
+		t.logger.Warn("Idling TCP connection on [%s] from %s for %v (%.1f%% idle rate)",
+			t.config.Name, conn.RemoteAddr(), t.config.IdleDuration, t.config.IdlePercent)
+		t.metrics.TCPBackendIdleDuration.WithLabelValues(t.config.Name).Observe(t.config.IdleDuration.Seconds())
+		t.wait(ctx, t.config.IdleDuration)
+	case "echo":
+		sent, _ = io.Copy(conn, &countingReader{Reader: conn, count: &received})
+	case "banner":
+		n, _ := io.WriteString(conn, t.config.Banner)
+		sent = int64(n)
+	case "no_read":
+		// Never read so the receive window fills up and the peer stalls
+		t.wait(ctx, t.config.HoldDuration)
+	case "reset":
+		// SO_LINGER 0 makes Close send a RST instead of a FIN
+		if tcpConn, ok := netConnOf(conn).(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+	}
+
+	conn.Close()
+
+	duration := time.Since(start)
+	t.metrics.TCPBackendConnectionsTotal.WithLabelValues(t.config.Name, action).Inc()
+	t.metrics.TCPBackendConnectionDuration.WithLabelValues(t.config.Name).Observe(duration.Seconds())
+	if received > 0 {
+		t.metrics.TCPBackendBytesTotal.WithLabelValues(t.config.Name, "received").Add(float64(received))
+	}
+	if sent > 0 {
+		t.metrics.TCPBackendBytesTotal.WithLabelValues(t.config.Name, "sent").Add(float64(sent))
+	}
+
+	t.logger.Debug("TCP [%s] connection from %s closed (%s, received %d bytes, sent %d bytes, took %v)",
+		t.config.Name, conn.RemoteAddr(), action, received, sent, duration)
+}
+
+// wait blocks for d, or until shutdown when d is zero
+func (t *TCPListener) wait(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		<-ctx.Done()
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// track registers an open connection so it can be closed on shutdown
+func (t *TCPListener) track(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conns[conn] = struct{}{}
+}
+
+// untrack removes a closed connection
+func (t *TCPListener) untrack(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+// closeAll closes every open connection
+func (t *TCPListener) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+}
+
+// netConnOf returns the connection underneath TLS and shaping wrappers, or conn itself
+func netConnOf(conn net.Conn) net.Conn {
+	for {
+		nc, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = nc.NetConn()
+	}
+}
+
+// findConn returns the first connection of type T among conn and the
+// connections it wraps
+func findConn[T net.Conn](conn net.Conn) (T, bool) {
+	for {
+		if c, ok := conn.(T); ok {
+			return c, true
+		}
+		nc, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		conn = nc.NetConn()
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	count *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	*r.count += int64(n)
+	return n, err
+}
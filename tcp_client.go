@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// executeTCPProbe dials a raw TCP endpoint, optionally writes a payload and
+// reads the response to measure connect time, first byte time and close behaviour
+func (c *Client) executeTCPProbe(ctx context.Context, runner *endpointRunner, attempt int) (err error) {
+	endpoint := runner.config
+	probe := endpoint.TCP
+	start := time.Now()
+	var conn net.Conn
+	var connectDuration, firstByteDuration time.Duration
+	var received []byte
+
+	// Record the attempt in the result stream once it completes
+	result := &requestResult{
+		Timestamp: start,
+		Endpoint:  endpoint.Name,
+		Type:      endpoint.Type,
+		Method:    endpoint.Method,
+		URL:       endpoint.URL,
+		Attempt:   attempt,
+	}
+	defer func() {
+		result.TCPMs = milliseconds(connectDuration)
+		result.TTFBMs = milliseconds(firstByteDuration)
+		result.TotalMs = milliseconds(time.Since(start))
+		result.BytesReceived = int64(len(received))
+		result.setConn(conn)
+		c.writeResult(result, err)
+	}()
+
+	u, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	address := u.Host
+
+	c.logger.Info("→ [%s] TCP connect %s (attempt %d)", endpoint.Name, address, attempt)
+
+	// Connect, recording the lookup when the dialer has to resolve the host
+	var dnsInfo *httptrace.DNSDoneInfo
+	trace := &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			dnsInfo = &info
+			c.recordDNS(endpoint, info)
+		},
+	}
+	conn, err = runner.dialer.DialContext(httptrace.WithClientTrace(ctx, trace), "tcp", address)
+	if dnsInfo != nil && dnsInfo.Err == nil {
+		result.ResolvedAddrs = ipStrings(dnsInfo.Addrs)
+	}
+	if err != nil {
+		outcome := classifyDialError(err)
+		c.metrics.TCPClientProbesTotal.WithLabelValues(endpoint.Name, outcome).Inc()
+		c.recordError(ctx, runner, outcome)
+		result.ErrorClass = outcome
+		return fmt.Errorf("connect failed (%s): %w", outcome, err)
+	}
+	defer conn.Close()
+
+	connectDuration = time.Since(start)
+	c.metrics.TCPClientConnectDuration.WithLabelValues(endpoint.Name).Observe(connectDuration.Seconds())
+
+	// Write payload
+	if probe.Payload != "" {
+		conn.SetWriteDeadline(time.Now().Add(probe.ReadTimeout))
+		n, err := io.WriteString(conn, probe.Payload)
+		result.BytesSent = int64(n)
+		if err != nil {
+			c.metrics.TCPClientProbesTotal.WithLabelValues(endpoint.Name, "write_failed").Inc()
+			c.recordError(ctx, runner, "write_failed")
+			result.ErrorClass = "write_failed"
+			return fmt.Errorf("failed to write payload: %w", err)
+		}
+	}
+
+	// Read until the expectation matches, the peer closes, or the read timeout expires
+	conn.SetReadDeadline(time.Now().Add(probe.ReadTimeout))
+	matched := false
+	closeType := "local"
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if firstByteDuration == 0 {
+				firstByteDuration = time.Since(start)
+			}
+			received = append(received, buf[:n]...)
+			if runner.expectRegex != nil && runner.expectRegex.Match(received) {
+				matched = true
+				break
+			}
+			if len(received) >= probe.MaxReadBytes {
+				break
+			}
+		}
+		if err != nil {
+			closeType = classifyCloseError(err)
+			break
+		}
+	}
+
+	totalDuration := time.Since(start)
+
+	if firstByteDuration > 0 {
+		c.metrics.TCPClientFirstByteDuration.WithLabelValues(endpoint.Name).Observe(firstByteDuration.Seconds())
+	}
+	c.metrics.TCPClientCloseTotal.WithLabelValues(endpoint.Name, closeType).Inc()
+	c.metrics.TCPClientBytesReceived.WithLabelValues(endpoint.Name).Observe(float64(len(received)))
+	runner.stats.observe(phaseTotal, totalDuration)
+	runner.stats.observe(phaseTCP, connectDuration)
+	if firstByteDuration > 0 {
+		runner.stats.observe(phaseTTFB, firstByteDuration)
+	}
+
+	c.logger.Info("← [%s] TCP connected in %v, received %d bytes, close: %s, Duration: %v",
+		endpoint.Name, connectDuration, len(received), closeType, totalDuration)
+
+	if c.logger.verbose {
+		c.logger.Debug("  Diagnostics for [%s]:", endpoint.Name)
+		c.logger.Debug("    Local Address: %s", conn.LocalAddr())
+		c.logger.Debug("    Remote Address: %s", conn.RemoteAddr())
+		c.logger.Debug("    TCP Connect: %v", connectDuration)
+		if firstByteDuration > 0 {
+			c.logger.Debug("    Time to First Byte: %v", firstByteDuration)
+		}
+		c.logger.Debug("    Close: %s", closeType)
+		c.logger.Debug("    Total Time: %v", totalDuration)
+
+		data := string(received)
+		if len(data) > 500 {
+			data = data[:500] + "... (truncated)"
+		}
+		if len(data) > 0 {
+			c.logger.Debug("  Received: %q", data)
+		}
+	}
+
+	if runner.expectRegex != nil && !matched {
+		c.metrics.TCPClientProbesTotal.WithLabelValues(endpoint.Name, "expect_mismatch").Inc()
+		c.recordError(ctx, runner, "expect_mismatch")
+		result.ErrorClass = "expect_mismatch"
+		return fmt.Errorf("response did not match %q (close: %s)", probe.ExpectRegex, closeType)
+	}
+
+	c.metrics.TCPClientProbesTotal.WithLabelValues(endpoint.Name, "success").Inc()
+	return nil
+}
+
+// classifyDialError maps a dial error to a probe outcome label
+func classifyDialError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return classifyDNSError(dnsErr)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connect_refused"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "connect_timeout"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "connect_unreachable"
+	}
+	return "connect_failed"
+}
+
+// classifyCloseError maps the error that ended the read phase to a close type
+func classifyCloseError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF):
+		return "fin"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "rst"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "error"
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Identity of the process answering, for "which pod answered this?"
+var (
+	hostname, _  = os.Hostname()
+	podName      = envOr("POD_NAME", hostname)
+	podNamespace = os.Getenv("POD_NAMESPACE")
+	nodeName     = os.Getenv("NODE_NAME")
+)
+
+// responseTemplate renders the body and header values of a templated endpoint
+type responseTemplate struct {
+	body     *template.Template
+	headers  map[string]*template.Template
+	requests atomic.Uint64 // Requests rendered since the endpoint was created or changed
+	random   *lockedRand   // Draws of randInt and randString
+}
+
+// templateFuncs are the functions available to response and request
+// templates in addition to the text/template builtins, except for the random
+// ones added by newTemplateFuncs
+var templateFuncs = template.FuncMap{
+	"uuid":   newUUID,
+	"now":    time.Now,
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+	"repeat": strings.Repeat,
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// newTemplateFuncs returns templateFuncs with randInt and randString drawing
+// from random, so templates follow the run seed like the fault decisions
+func newTemplateFuncs(random *lockedRand) template.FuncMap {
+	funcs := maps.Clone(templateFuncs)
+	funcs["randInt"] = func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + random.IntN(max-min+1)
+	}
+	funcs["randString"] = func(n int) string {
+		return randomString(random, n)
+	}
+	return funcs
+}
+
+// newResponseTemplate parses the body and header values of an endpoint. Its
+// request count and random sequence start over.
+func newResponseTemplate(endpoint BackendEndpoint) (*responseTemplate, error) {
+	t := &responseTemplate{
+		headers: make(map[string]*template.Template),
+		random:  newLockedRand("template " + endpoint.streamName()),
+	}
+	funcs := newTemplateFuncs(t.random)
+	var err error
+	if t.body, err = template.New("body").Funcs(funcs).Parse(endpoint.Body); err != nil {
+		return nil, err
+	}
+	for key, value := range endpoint.Headers {
+		if t.headers[key], err = template.New(key).Funcs(funcs).Parse(value); err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+	}
+	return t, nil
+}
+
+// render executes the templates for r and returns the header values and body
+func (t *responseTemplate) render(r *http.Request, start time.Time) (map[string]string, []byte, error) {
+	data := newTemplateData(r, start, t.requests.Add(1))
+
+	headers := make(map[string]string, len(t.headers))
+	var out strings.Builder
+	for key, tmpl := range t.headers {
+		out.Reset()
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, nil, fmt.Errorf("header %s: %w", key, err)
+		}
+		headers[key] = out.String()
+	}
+
+	out.Reset()
+	if err := t.body.Execute(&out, data); err != nil {
+		return nil, nil, fmt.Errorf("body: %w", err)
+	}
+	return headers, []byte(out.String()), nil
+}
+
+// templateData is the request context available to response templates as "."
+type templateData struct {
+	Method        string
+	Path          string
+	URI           string // Path and query as sent by the client
+	Proto         string
+	Host          string
+	Query         url.Values
+	Header        http.Header
+	ContentLength int64  // Declared request body size, -1 when unknown
+	RemoteAddr    string // Client address and port
+	LocalAddr     string // Address and port of the backend the connection arrived on
+	Hostname      string
+	Pod           string // POD_NAME, or the hostname when unset
+	Namespace     string // POD_NAMESPACE
+	Node          string // NODE_NAME
+	Count         uint64 // Number of this request to the endpoint, starting at 1
+	Time          time.Time
+
+	request *http.Request
+	body    *string
+}
+
+func newTemplateData(r *http.Request, start time.Time, count uint64) *templateData {
+	data := &templateData{
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		URI:           r.RequestURI,
+		Proto:         r.Proto,
+		Host:          r.Host,
+		Query:         r.URL.Query(),
+		Header:        r.Header,
+		ContentLength: r.ContentLength,
+		RemoteAddr:    r.RemoteAddr,
+		Hostname:      hostname,
+		Pod:           podName,
+		Namespace:     podNamespace,
+		Node:          nodeName,
+		Count:         count,
+		Time:          start,
+		request:       r,
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		data.LocalAddr = addr.String()
+	}
+	return data
+}
+
+// PathValue returns a wildcard of the endpoint path, e.g. {{.PathValue "id"}}
+func (d *templateData) PathValue(name string) string {
+	return d.request.PathValue(name)
+}
+
+// Body returns the start of the request body, read on first use
+func (d *templateData) Body() string {
+	if d.body == nil {
+		body := string(peekBody(d.request))
+		d.body = &body
+	}
+	return *d.body
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// randomString returns n random letters and digits drawn from random
+func randomString(random *lockedRand, n int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, max(0, n))
+	for i := range b {
+		b[i] = alphabet[random.IntN(len(alphabet))]
+	}
+	return string(b)
+}
+
+// envOr returns the environment variable key, or fallback when it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTemplateRandomFollowsSeed(t *testing.T) {
+	defer seedRandom(randomSeed)
+	endpoint := BackendEndpoint{Path: "/random", Template: true, Body: "{{randInt 1 1000000}} {{randString 16}}"}
+	render := func(seed uint64) string {
+		seedRandom(seed)
+		tmpl, err := newResponseTemplate(endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, body, err := tmpl.render(httptest.NewRequest("GET", "/random", nil), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	if first, again := render(42), render(42); first != again {
+		t.Errorf("seed 42 rendered %q, then %q", first, again)
+	}
+	if first, other := render(42), render(43); first == other {
+		t.Errorf("seeds 42 and 43 both rendered %q", first)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes for violated threshold classes. They are bit flags, so a run that
+// violates several classes exits with their sum (e.g. 6 = latency + error rate).
+const (
+	exitLatencyThreshold   = 2
+	exitErrorRateThreshold = 4
+	exitRPSThreshold       = 8
+)
+
+var thresholdPattern = regexp.MustCompile(`^\s*([a-z0-9_.]+)\s*(<=|>=|<|>)\s*(.+?)\s*$`)
+
+// threshold is a parsed SLO such as "p99 < 300ms", "error_rate < 1%" or "rps >= 0.95 * target"
+type threshold struct {
+	expr     string
+	class    string // latency, error_rate or rps
+	phase    string // Timing phase for latency thresholds
+	quantile string // p50, p90, p99, p99.9 or max
+	op       string
+	value    float64 // Seconds, fraction of requests or requests per second
+	relative bool    // value is a factor of the endpoint's target RPS
+}
+
+// parseThreshold parses a threshold expression
+func parseThreshold(expr string) (*threshold, error) {
+	m := thresholdPattern.FindStringSubmatch(strings.ToLower(expr))
+	if m == nil {
+		return nil, fmt.Errorf("invalid threshold %q: expected '<metric> <op> <value>'", expr)
+	}
+	t := &threshold{expr: expr, op: m[2]}
+	metric, value := m[1], m[3]
+
+	switch metric {
+	case "error_rate":
+		t.class = "error_rate"
+		percent, isPercent := strings.CutSuffix(value, "%")
+		v, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: error_rate needs a percentage or fraction", expr)
+		}
+		if isPercent {
+			v /= 100
+		}
+		t.value = v
+	case "rps":
+		t.class = "rps"
+		factor, isRelative := strings.CutSuffix(value, "target")
+		factor = strings.TrimSuffix(strings.TrimSpace(factor), "*")
+		if isRelative {
+			t.relative = true
+			t.value = 1
+			if factor = strings.TrimSpace(factor); factor == "" {
+				break
+			}
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(factor), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: rps needs a number, 'target' or '<factor> * target'", expr)
+		}
+		t.value = v
+	default:
+		t.class = "latency"
+		t.phase = phaseTotal
+		if phase, quantile, ok := strings.Cut(metric, "."); ok && slices.Contains(reportPhases, phase) {
+			t.phase, metric = phase, quantile
+		}
+		switch metric {
+		case "p50", "p90", "p99", "p99.9", "max":
+			t.quantile = metric
+		default:
+			return nil, fmt.Errorf("invalid threshold %q: unknown metric %s", expr, m[1])
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: latency needs a duration", expr)
+		}
+		t.value = d.Seconds()
+	}
+
+	return t, nil
+}
+
+// exitCode returns the exit code flag of the threshold class
+func (t *threshold) exitCode() int {
+	switch t.class {
+	case "latency":
+		return exitLatencyThreshold
+	case "error_rate":
+		return exitErrorRateThreshold
+	}
+	return exitRPSThreshold
+}
+
+// evaluate checks the threshold against an endpoint's results. It returns the
+// measured and the limit values formatted for logging, and false when violated.
+func (t *threshold) evaluate(e EndpointReport) (actual, limit string, ok bool) {
+	var measured, bound float64
+	switch t.class {
+	case "error_rate":
+		if e.Requests > 0 {
+			measured = float64(e.Failed) / float64(e.Requests)
+		}
+		bound = t.value
+		actual, limit = fmt.Sprintf("%.2f%%", measured*100), fmt.Sprintf("%.2f%%", bound*100)
+	case "rps":
+		measured, bound = e.AchievedRPS, t.value
+		if t.relative {
+			bound *= e.TargetRPS
+		}
+		actual, limit = fmt.Sprintf("%.2f", measured), fmt.Sprintf("%.2f", bound)
+	default:
+		var summary *LatencySummary
+		for i := range e.Latency {
+			if e.Latency[i].Phase == t.phase {
+				summary = &e.Latency[i]
+			}
+		}
+		bound = t.value
+		limit = time.Duration(bound * float64(time.Second)).String()
+		if summary == nil {
+			// No completed request leaves nothing to vouch for, but a phase alone
+			// may be unmeasured, e.g. no new connections for dns or tls
+			return "no data", limit, len(e.Latency) > 0
+		}
+		d := map[string]time.Duration{
+			"p50": summary.P50, "p90": summary.P90, "p99": summary.P99, "p99.9": summary.P999, "max": summary.Max,
+		}[t.quantile]
+		measured, actual = d.Seconds(), formatLatency(d).String()
+	}
+
+	switch t.op {
+	case "<":
+		ok = measured < bound
+	case "<=":
+		ok = measured <= bound
+	case ">":
+		ok = measured > bound
+	case ">=":
+		ok = measured >= bound
+	}
+	return actual, limit, ok
+}
+
+// evaluateThresholds checks every threshold against every endpoint of the report,
+// logs the results and returns the process exit code (0 when all passed)
+func evaluateThresholds(expressions []string, report *RunReport, logger *Logger) int {
+	code := 0
+	for _, expr := range expressions {
+		t, err := parseThreshold(expr)
+		if err != nil {
+			logger.Error("%v", err)
+			continue
+		}
+		for _, e := range report.Endpoints {
+			actual, limit, ok := t.evaluate(e)
+			if ok {
+				logger.Info("Threshold passed [%s]: %s (actual: %s)", e.Name, t.expr, actual)
+				continue
+			}
+			logger.Error("Threshold violated [%s]: %s (actual: %s, limit: %s)", e.Name, t.expr, actual, limit)
+			code |= t.exitCode()
+		}
+	}
+	return code
+}
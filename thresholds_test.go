@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestLatencyThresholdWithoutData(t *testing.T) {
+	measured := EndpointReport{Name: "measured", Latency: []LatencySummary{{Phase: phaseTotal, Count: 1}}}
+	unmeasured := EndpointReport{Name: "unmeasured", Requests: 10, Failed: 10}
+
+	tests := []struct {
+		expr     string
+		endpoint EndpointReport
+		want     bool
+	}{
+		{"p99 < 300ms", unmeasured, false},
+		{"dns.p99 < 300ms", unmeasured, false},
+		{"p99 < 300ms", measured, true},
+		{"dns.p99 < 300ms", measured, true}, // Reused connections
+	}
+	for _, tt := range tests {
+		th, err := parseThreshold(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, _, ok := th.evaluate(tt.endpoint); ok != tt.want {
+			t.Errorf("%s on %s: passed %v (actual: %s), want %v", tt.expr, tt.endpoint.Name, ok, actual, tt.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds how long a client may take to complete the handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// parseTLSVersion converts a configured version string into a crypto/tls constant
+func parseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToUpper(version), "TLS") {
+	case "":
+		return 0, nil
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version: %s", version)
+}
+
+// parseCipherSuites converts IANA cipher suite names into crypto/tls IDs
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", filename)
+	}
+	return pool, nil
+}
+
+// buildClientTLSConfig creates the tls.Config used by an endpoint's transport
+func buildClientTLSConfig(cfg *TLSClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		ServerName:         cfg.ServerName,
+		NextProtos:         cfg.ALPN,
+	}
+
+	var err error
+	if tlsConfig.MinVersion, err = parseTLSVersion(cfg.MinVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MaxVersion, err = parseTLSVersion(cfg.MaxVersion); err != nil {
+		return nil, err
+	}
+
+	if cfg.CAFile != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(cfg.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// classifyClientTLSError maps a client-side handshake error to an error_type label
+func classifyClientTLSError(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return "tls_unknown_authority"
+	case errors.As(err, &hostnameErr):
+		return "tls_hostname_mismatch"
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return "tls_cert_expired"
+	case errors.As(err, &invalidErr):
+		return "tls_cert_invalid"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "tls_timeout"
+	case strings.Contains(err.Error(), "remote error"):
+		return "tls_remote_alert"
+	}
+	return "tls_handshake_failed"
+}
+
+// daysUntilExpiry returns the number of days left before cert expires (negative once expired)
+func daysUntilExpiry(cert *x509.Certificate) float64 {
+	return time.Until(cert.NotAfter).Hours() / 24
+}
+
+// sniCertificate is a certificate served for a set of server names
+type sniCertificate struct {
+	serverNames []string
+	cert        *tls.Certificate
+}
+
+// matches reports whether the certificate should be served for serverName
+func (s *sniCertificate) matches(serverName string) bool {
+	serverName = strings.ToLower(serverName)
+	for _, name := range s.serverNames {
+		name = strings.ToLower(name)
+		if name == serverName {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") {
+			if i := strings.IndexByte(serverName, '.'); i > 0 && serverName[i:] == name[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildServerTLSConfig creates the tls.Config for a backend listener
+func buildServerTLSConfig(cfg *TLSServerConfig, logger *Logger) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	var err error
+	if tlsConfig.MinVersion, err = parseTLSVersion(cfg.MinVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MaxVersion, err = parseTLSVersion(cfg.MaxVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.CipherSuites, err = parseCipherSuites(cfg.CipherSuites); err != nil {
+		return nil, err
+	}
+
+	var generated *generatedCerts
+	if cfg.AutoGenerate != nil {
+		generated, err = generateCertificates(cfg.AutoGenerate, cfg.SNI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate certificates: %w", err)
+		}
+		logger.Info("Generated self-signed CA and certificates in %s", cfg.AutoGenerate.Dir)
+	}
+
+	// Default certificate
+	var defaultCert *tls.Certificate
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		defaultCert = &cert
+	} else if generated != nil {
+		defaultCert = generated.server
+	}
+
+	// Certificates selected by SNI
+	sniCerts := make([]*sniCertificate, 0, len(cfg.SNI))
+	for i, sni := range cfg.SNI {
+		entry := &sniCertificate{serverNames: sni.ServerNames}
+		if sni.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(sni.CertFile, sni.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("sni entry %d: failed to load certificate: %w", i, err)
+			}
+			entry.cert = &cert
+		} else {
+			entry.cert = generated.sni[i]
+		}
+		sniCerts = append(sniCerts, entry)
+	}
+	if defaultCert == nil {
+		defaultCert = sniCerts[0].cert
+	}
+
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		for _, entry := range sniCerts {
+			if entry.matches(hello.ServerName) {
+				logger.Debug("TLS SNI %q matched %v", hello.ServerName, entry.serverNames)
+				return entry.cert, nil
+			}
+		}
+		return defaultCert, nil
+	}
+
+	// Client certificate verification
+	var clientCAs *x509.CertPool
+	if cfg.ClientCAFile != "" {
+		if clientCAs, err = loadCertPool(cfg.ClientCAFile); err != nil {
+			return nil, err
+		}
+	} else if generated != nil {
+		clientCAs = generated.caPool
+	}
+	tlsConfig.ClientCAs = clientCAs
+
+	switch cfg.ClientAuth {
+	case "request":
+		if clientCAs != nil {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			tlsConfig.ClientAuth = tls.RequestClientCert
+		}
+	case "require":
+		if clientCAs != nil {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.RequireAnyClientCert
+		}
+	default:
+		tlsConfig.ClientAuth = tls.NoClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsListener wraps a listener and completes TLS handshakes before handing
+// connections out, so that handshake failures can be classified and counted
+type tlsListener struct {
+	net.Listener
+	name      string
+	config    *tls.Config
+	logger    *Logger
+	metrics   *Metrics
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newTLSListener starts accepting connections on inner and handshaking them in the background
+func newTLSListener(inner net.Listener, name string, config *tls.Config, logger *Logger, metrics *Metrics) *tlsListener {
+	l := &tlsListener{
+		Listener: inner,
+		name:     name,
+		config:   config,
+		logger:   logger,
+		metrics:  metrics,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// acceptLoop accepts raw connections and handshakes each one in its own
+// goroutine. Like http.Server, it retries failed accepts (such as running out
+// of file descriptors) with a backoff, and only stops once the listener is closed.
+func (l *tlsListener) acceptLoop() {
+	var delay time.Duration
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				select {
+				case l.errs <- err:
+				case <-l.done:
+				}
+				return
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			l.logger.Warn("Accept failed on %s: %v; retrying in %v", l.name, err, delay)
+			select {
+			case <-time.After(delay):
+			case <-l.done:
+				return
+			}
+			continue
+		}
+		delay = 0
+		go l.handshake(conn)
+	}
+}
+
+// handshake completes the TLS handshake and queues the connection for Accept
+func (l *tlsListener) handshake(conn net.Conn) {
+	tlsConn := tls.Server(conn, l.config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		reason := classifyHandshakeError(err)
+		l.metrics.BackendTLSHandshakeFailures.WithLabelValues(l.name, reason).Inc()
+		l.logger.Warn("TLS handshake failed on %s from %s: %v (%s)", l.name, conn.RemoteAddr(), err, reason)
+		conn.Close()
+		return
+	}
+
+	state := tlsConn.ConnectionState()
+	l.logger.Debug("TLS handshake completed on %s from %s: %s, %s, SNI %q",
+		l.name, conn.RemoteAddr(), tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), state.ServerName)
+
+	select {
+	case l.conns <- tlsConn:
+	case <-l.done:
+		tlsConn.Close()
+	}
+}
+
+// Accept returns the next connection whose handshake succeeded
+func (l *tlsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *tlsListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.Listener.Close()
+	})
+	return err
+}
+
+// classifyHandshakeError maps a server-side handshake error to a metric reason
+func classifyHandshakeError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return "connection_closed"
+	}
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &recordErr) {
+		return "not_tls"
+	}
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		return "bad_client_cert"
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "remote error: tls: bad certificate"),
+		strings.Contains(msg, "remote error: tls: unknown certificate"),
+		strings.Contains(msg, "remote error: tls: certificate"):
+		return "client_rejected_cert"
+	case strings.Contains(msg, "remote error"):
+		return "client_alert"
+	case strings.Contains(msg, "didn't provide a certificate"):
+		return "no_client_cert"
+	case strings.Contains(msg, "unsupported versions"), strings.Contains(msg, "protocol version"):
+		return "version_mismatch"
+	case strings.Contains(msg, "no cipher suite"), strings.Contains(msg, "no mutual"):
+		return "no_shared_cipher"
+	case strings.Contains(msg, "no application protocol"):
+		return "alpn_mismatch"
+	}
+	return "other"
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingListener fails its first accepts with EMFILE, then reports itself closed
+type failingListener struct {
+	net.Listener
+	failures int
+	accepts  chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts <- struct{}{}
+	if l.failures > 0 {
+		l.failures--
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+	return nil, net.ErrClosed
+}
+
+func TestTLSListenerRetriesAcceptErrors(t *testing.T) {
+	inner := &failingListener{failures: 3, accepts: make(chan struct{}, 10)}
+	l := newTLSListener(inner, "test", nil, NewLogger(LoggingConfig{Level: "error"}), testMetrics())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+	for range 4 {
+		select {
+		case <-inner.accepts:
+		case err := <-done:
+			t.Fatalf("Accept returned %v while the listener was retrying", err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("accept was not retried")
+		}
+	}
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept returned %v once the listener closed, want net.ErrClosed", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// newHTTPClient builds the HTTP client used for a single endpoint
+func newHTTPClient(config *ClientConfig, endpoint EndpointConfig, conns *connTracker) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer, err := newEndpointDialer(endpoint.DNS, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint [%s]: dns: %w", endpoint.Name, err)
+	}
+	dialer.shaper = newShaper(endpoint.Shaping, "client", endpoint.Name, conns.metrics)
+	transport.DialContext = conns.dialContext(dialer.DialContext)
+
+	if c := endpoint.Connection; c != nil {
+		transport.DisableKeepAlives = c.DisableKeepAlive
+		if c.MaxIdleConns > 0 {
+			transport.MaxIdleConns = c.MaxIdleConns
+			transport.MaxIdleConnsPerHost = c.MaxIdleConns
+		}
+		if c.IdleTimeout > 0 {
+			transport.IdleConnTimeout = c.IdleTimeout
+		}
+		transport.MaxConnsPerHost = c.MaxConnsPerHost
+		if c.NewConnectionPerRequest {
+			// A negative limit makes the pool reject every connection, so each one
+			// is closed after its response without a "Connection: close" header.
+			// HTTP/2 connections are shared by design and are not affected.
+			transport.MaxIdleConnsPerHost = -1
+		}
+	}
+
+	if endpoint.TLS != nil {
+		tlsConfig, err := buildClientTLSConfig(endpoint.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint [%s]: %w", endpoint.Name, err)
+		}
+		transport.TLSClientConfig = tlsConfig
+
+		// The transport prepends h2 to a custom ALPN list unless HTTP/2 is disabled
+		if len(endpoint.TLS.ALPN) > 0 && !slices.Contains(endpoint.TLS.ALPN, "h2") {
+			transport.ForceAttemptHTTP2 = false
+			transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		}
+	}
+
+	// Force a protocol instead of negotiating it with ALPN
+	switch endpoint.Protocol {
+	case "http1":
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP1(true)
+	case "h2":
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+	case "h2c":
+		// HTTP/2 with prior knowledge: the connection starts with the HTTP/2 preface
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return &http.Client{
+		Timeout:   config.RequestTimeout,
+		Transport: transport,
+	}, nil
+}
+
+// sameTransport reports whether two endpoint definitions need identical transports
+func sameTransport(a, b EndpointConfig) bool {
+	return reflect.DeepEqual(a.TLS, b.TLS) && reflect.DeepEqual(a.Connection, b.Connection) && reflect.DeepEqual(a.DNS, b.DNS) &&
+		a.Protocol == b.Protocol && reflect.DeepEqual(a.Shaping, b.Shaping)
+}
+
+// connTracker follows the connections of one endpoint transport to export
+// reuse, open connection and connection age metrics
+type connTracker struct {
+	endpoint string
+	metrics  *Metrics
+	obtained atomic.Int64
+	reused   atomic.Int64
+}
+
+// trackedConn is a client connection that knows when it was opened
+type trackedConn struct {
+	net.Conn
+	created time.Time
+	once    sync.Once
+	onClose func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
+
+func newConnTracker(endpoint string, metrics *Metrics) *connTracker {
+	return &connTracker{endpoint: endpoint, metrics: metrics}
+}
+
+// dialContext wraps dial so every connection is counted while it is open
+func (t *connTracker) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		open := t.metrics.ClientOpenConnections.WithLabelValues(t.endpoint)
+		open.Inc()
+		return &trackedConn{Conn: conn, created: time.Now(), onClose: open.Dec}, nil
+	}
+}
+
+// gotConn records a connection obtained for a request and returns its age
+func (t *connTracker) gotConn(info httptrace.GotConnInfo) time.Duration {
+	obtained := t.obtained.Add(1)
+	reused := t.reused.Load()
+	if info.Reused {
+		reused = t.reused.Add(1)
+	}
+	t.metrics.ClientConnectionsTotal.WithLabelValues(t.endpoint, strconv.FormatBool(info.Reused)).Inc()
+	t.metrics.ClientConnReuseRatio.WithLabelValues(t.endpoint).Set(float64(reused) / float64(obtained))
+
+	age := connAge(info.Conn)
+	if info.Reused && age > 0 {
+		t.metrics.ClientConnAgeAtReuse.WithLabelValues(t.endpoint).Observe(age.Seconds())
+	}
+	return age
+}
+
+// putIdleConn records whether the idle pool kept a connection after its response
+func (t *connTracker) putIdleConn(err error) {
+	outcome := "pooled"
+	if err != nil {
+		outcome = "closed"
+	}
+	t.metrics.ClientIdleConnReturns.WithLabelValues(t.endpoint, outcome).Inc()
+}
+
+// connAge returns how long ago a tracked connection was opened
+func connAge(conn net.Conn) time.Duration {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tracked, ok := conn.(*trackedConn); ok {
+		return time.Since(tracked.created)
+	}
+	return 0
+}